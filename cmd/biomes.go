@@ -0,0 +1,65 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"github.com/mdhender/mapgen/pkg/mapstore"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+var biomesArgs struct {
+	pctWater int
+}
+
+var biomesCmd = &cobra.Command{
+	Use:   "biomes id",
+	Short: "Print the area of each biome on a map",
+	Long: `Print the area of each biome on a map.
+
+Biomes come from the temperature, precipitation, and height of every pixel,
+using the same climate as "mapgen render --mode biome".
+Areas are in square kilometers, with the map as wide as the equator,
+and allow for pixels near the poles covering less ground.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := mapstore.NewFileStore(rootArgs.dataDir, heightmap.EncodeOptions{})
+		if err != nil {
+			return err
+		}
+		hm, err := store.Get(args[0])
+		if err != nil {
+			return err
+		}
+		style := heightmap.DefaultStyle
+		style.PctWater = biomesArgs.pctWater
+		c, err := style.Climate(hm)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "biome\tpixels\tkm²\tmap %%\tland %%\n")
+		for _, a := range c.BiomeAreas() {
+			fmt.Fprintf(w, "%s\t%d\t%.0f\t%.1f\t%.1f\n", a.Biome, a.Pixels, a.Area, a.Percent, a.PercentOfLand)
+		}
+		return w.Flush()
+	},
+}
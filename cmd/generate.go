@@ -17,7 +17,18 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/generators"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"github.com/mdhender/mapgen/pkg/mapstore"
 	"github.com/spf13/cobra"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
 )
 
 var generateCmd = &cobra.Command{
@@ -28,3 +39,152 @@ var generateCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 	},
 }
+
+// newGenerateCmd returns a command that runs the generator.
+// The flags for the command are derived from the generator's parameters.
+func newGenerateCmd(g generators.Generator) *cobra.Command {
+	var args struct {
+		force    bool
+		timeout  time.Duration
+		seed     int64
+		dataType string
+		compress bool
+		// params returns the values of the flags for the parameters
+		params func() generators.Params
+	}
+
+	cmd := &cobra.Command{
+		Use:   g.Name(),
+		Short: g.Description(),
+		Long:  g.Description() + ".",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			params, err := generators.Validate(g.Parameters(), args.params())
+			if err != nil {
+				return err
+			}
+
+			log.Printf("generator  %12s\n", g.Name())
+			log.Printf("seed       %12d\n", args.seed)
+			for _, parm := range g.Parameters() {
+				log.Printf("%-10s %12s\n", parm.Name, params[parm.Name])
+			}
+
+			opts, err := encodeOptions(args.dataType, args.compress)
+			if err != nil {
+				return err
+			}
+			return generateMap(cmd.Context(), g, params, args.seed, args.timeout, opts, args.force)
+		},
+	}
+	cmd.Flags().BoolVar(&args.compress, "compress", false, "Compress the elevation data")
+	cmd.Flags().StringVar(&args.dataType, "data-type", "float32", "Type used to store elevations (float32, float64, or uint16)")
+	cmd.Flags().BoolVarP(&args.force, "force", "f", false, "Overwrite any existing files")
+	cmd.Flags().Int64VarP(&args.seed, "seed", "s", 0, "Seed for generator")
+	cmd.Flags().DurationVar(&args.timeout, "timeout", 0, "Stop the generator after this long (0 for no limit)")
+	if err := cmd.MarkFlagRequired("seed"); err != nil {
+		log.Fatal(err)
+	}
+	args.params = paramFlags(cmd, g.Parameters())
+
+	return cmd
+}
+
+// paramFlags adds a flag to the command for each parameter in the schema.
+// It returns a function that returns the values of the flags.
+func paramFlags(cmd *cobra.Command, schema []generators.Parameter) func() generators.Params {
+	values := make(map[string]func() string)
+	for _, parm := range schema {
+		switch parm.Kind {
+		case generators.Bool:
+			dflt, _ := strconv.ParseBool(parm.Default)
+			val := cmd.Flags().BoolP(parm.Name, parm.Shorthand, dflt, parm.Usage)
+			values[parm.Name] = func() string { return strconv.FormatBool(*val) }
+		case generators.Float:
+			dflt, _ := strconv.ParseFloat(parm.Default, 64)
+			val := cmd.Flags().Float64P(parm.Name, parm.Shorthand, dflt, parm.Usage)
+			values[parm.Name] = func() string { return strconv.FormatFloat(*val, 'g', -1, 64) }
+		case generators.Int:
+			dflt, _ := strconv.Atoi(parm.Default)
+			val := cmd.Flags().IntP(parm.Name, parm.Shorthand, dflt, parm.Usage)
+			values[parm.Name] = func() string { return strconv.Itoa(*val) }
+		default:
+			val := cmd.Flags().StringP(parm.Name, parm.Shorthand, parm.Default, parm.Usage)
+			values[parm.Name] = func() string { return *val }
+		}
+	}
+	return func() generators.Params {
+		params := generators.Params{}
+		for name, value := range values {
+			params[name] = value()
+		}
+		return params
+	}
+}
+
+// encodeOptions converts the flags for saving maps into options for the encoder.
+func encodeOptions(dataType string, compress bool) (heightmap.EncodeOptions, error) {
+	opts := heightmap.EncodeOptions{Compress: compress}
+	switch dataType {
+	case "float32":
+		opts.DataType = heightmap.Float32
+	case "float64":
+		opts.DataType = heightmap.Float64
+	case "uint16":
+		opts.DataType = heightmap.Uint16
+	default:
+		return opts, fmt.Errorf("data-type: must be float32, float64, or uint16")
+	}
+	return opts, nil
+}
+
+// generateMap runs the generator and saves the map, along with its provenance.
+// The parameters must have been validated.
+// The generator is stopped if the context is canceled or it runs longer
+// than the timeout; a timeout of zero means no limit.
+func generateMap(ctx context.Context, g generators.Generator, params generators.Params, seed int64, timeout time.Duration, opts heightmap.EncodeOptions, force bool) error {
+	store, err := mapstore.NewFileStore(rootArgs.dataDir, opts)
+	if err != nil {
+		return err
+	}
+
+	meta := &heightmap.Metadata{
+		Generator: g.Name(),
+		Params:    params,
+		Seed:      seed,
+		Version:   version,
+	}
+
+	id := meta.ID()
+	// does map already exist?
+	if _, err := store.Stat(id); err == nil {
+		if !force {
+			log.Printf("%s exists\n", id)
+			return os.ErrExist
+		}
+		log.Printf("will overwrite %s\n", id)
+	}
+	// create a new random source
+	rnd := rand.New(rand.NewSource(seed))
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	started := time.Now()
+	hm, err := g.Generate(ctx, params, rnd, nil)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("generator took longer than %v", timeout)
+	} else if err != nil {
+		return err
+	}
+	meta.Created, meta.Elapsed = started.UTC(), time.Now().Sub(started)
+	hm.Metadata = meta
+	log.Printf("create map, elapsed   %v\n", meta.Elapsed)
+	// save it
+	if err := store.Put(id, hm); err != nil {
+		log.Printf("error saving map\n")
+		return err
+	}
+	log.Printf("created %s, elapsed %v\n", id, time.Now().Sub(started))
+	return nil
+}
@@ -0,0 +1,27 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+// import the generators for their side effects.
+// they register themselves with the generators package,
+// which makes them available to both the command line and the server.
+// new generators only need to be added here.
+import (
+	_ "github.com/mdhender/mapgen/pkg/generators/flat"
+	_ "github.com/mdhender/mapgen/pkg/generators/fractal"
+	_ "github.com/mdhender/mapgen/pkg/generators/olsson"
+)
@@ -0,0 +1,76 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"github.com/mdhender/mapgen/pkg/mapstore"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var hydrologyArgs struct {
+	flood    bool
+	output   string
+	pctWater int
+	rivers   int
+}
+
+var hydrologyCmd = &cobra.Command{
+	Use:   "hydrology id",
+	Short: "Export the rivers and lakes of a map as GeoJSON",
+	Long: `Export the rivers and lakes of a map as a GeoJSON FeatureCollection.
+
+Water runs downhill until it reaches the sea or an edge of the map that
+doesn't wrap. Every river that drains at least --rivers pixels is exported
+as a LineString, with its flow at each point.
+
+Water that doesn't reach the ocean is exported as lakes, as Polygons with
+the height of their surface, spill point, and bottom. With --flood, every
+basin is filled up to its spill point first.
+Coordinates are in pixels, with x to the right and y down.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if hydrologyArgs.rivers < 0 {
+			return fmt.Errorf("rivers: must not be negative")
+		}
+		store, err := mapstore.NewFileStore(rootArgs.dataDir, heightmap.EncodeOptions{})
+		if err != nil {
+			return err
+		}
+		hm, err := store.Get(args[0])
+		if err != nil {
+			return err
+		}
+		bb, err := hm.Drainage(hm.SeaLevel(hydrologyArgs.pctWater), hydrologyArgs.flood).GeoJSON(float64(hydrologyArgs.rivers))
+		if err != nil {
+			return err
+		}
+
+		output := hydrologyArgs.output
+		if output == "" {
+			output = args[0] + "-hydrology.geojson"
+		}
+		if err := os.WriteFile(output, bb, 0644); err != nil {
+			return err
+		}
+		log.Printf("created %s\n", output)
+		return nil
+	},
+}
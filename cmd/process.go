@@ -0,0 +1,183 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/generators"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"github.com/mdhender/mapgen/pkg/mapstore"
+	"github.com/mdhender/mapgen/pkg/processors"
+	"github.com/spf13/cobra"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+var processArgs struct {
+	dataType string
+	compress bool
+	force    bool
+	output   string
+	timeout  time.Duration
+}
+
+var processCmd = &cobra.Command{
+	Use:   "process",
+	Short: "Change an existing map",
+	Long: `Change an existing map and save the result as a new map.
+The steps are recorded in the provenance of the new map.`,
+}
+
+var resizeArgs struct {
+	width  int
+	height int
+	method string
+}
+
+var processResizeCmd = &cobra.Command{
+	Use:   "resize id",
+	Short: "Change the resolution of a map",
+	Long: `Change the resolution of a map.
+If only one of --width or --height is given, the other keeps the aspect ratio of the map.
+Maps that wrap are resized without a seam at the edges.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		method, err := heightmap.ParseInterpolation(resizeArgs.method)
+		if err != nil {
+			return fmt.Errorf("method: %w", err)
+		} else if resizeArgs.width == 0 && resizeArgs.height == 0 {
+			return fmt.Errorf("width or height is required")
+		}
+		suffix := func(hm *heightmap.Map) string {
+			width, height := resizeSize(hm)
+			return fmt.Sprintf("%dx%d", width, height)
+		}
+		return processMap(cmd.Context(), args[0], suffix, func(_ context.Context, hm *heightmap.Map) (*heightmap.Map, string, error) {
+			width, height := resizeSize(hm)
+			nm, err := hm.Resize(width, height, method)
+			if err != nil {
+				return nil, "", err
+			}
+			return nm, fmt.Sprintf("resize %dx%d %s", width, height, method), nil
+		})
+	},
+}
+
+// newProcessCmd returns a command that runs the processor.
+// The flags for the command are derived from the processor's parameters.
+func newProcessCmd(p processors.Processor) *cobra.Command {
+	var args struct {
+		seed   int64
+		params func() generators.Params
+	}
+
+	cmd := &cobra.Command{
+		Use:   p.Name() + " id",
+		Short: p.Description(),
+		Long:  p.Description() + ".",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, ids []string) error {
+			params, err := generators.Validate(p.Parameters(), args.params())
+			if err != nil {
+				return err
+			}
+			suffix := func(*heightmap.Map) string { return p.Name() }
+			return processMap(cmd.Context(), ids[0], suffix, func(ctx context.Context, hm *heightmap.Map) (*heightmap.Map, string, error) {
+				nm, err := p.Process(ctx, hm, params, rand.New(rand.NewSource(args.seed)), nil)
+				if err != nil {
+					return nil, "", err
+				}
+				return nm, processors.Step(p, args.seed, params), nil
+			})
+		},
+	}
+	cmd.Flags().Int64VarP(&args.seed, "seed", "s", 0, "Seed for the processor")
+	args.params = paramFlags(cmd, p.Parameters())
+	return cmd
+}
+
+// resizeSize returns the size that the resize command changes the map to.
+func resizeSize(hm *heightmap.Map) (width, height int) {
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	width, height = resizeArgs.width, resizeArgs.height
+	if width == 0 {
+		width = int(math.Round(float64(maxx) * float64(height) / float64(maxy)))
+	} else if height == 0 {
+		height = int(math.Round(float64(maxy) * float64(width) / float64(maxx)))
+	}
+	return width, height
+}
+
+// processMap loads the map, changes it, and saves the result.
+// The change returns the new map and a description of the step for the provenance.
+// The new map is named for the output flag, or the id followed by the suffix,
+// which is given the map before it is changed.
+// If the new map exists and --force isn't set, processMap fails before making the change.
+// The change is stopped if the context is canceled or it takes longer than the timeout.
+func processMap(ctx context.Context, id string, suffix func(*heightmap.Map) string, change func(ctx context.Context, hm *heightmap.Map) (*heightmap.Map, string, error)) error {
+	opts, err := encodeOptions(processArgs.dataType, processArgs.compress)
+	if err != nil {
+		return err
+	}
+	store, err := mapstore.NewFileStore(rootArgs.dataDir, opts)
+	if err != nil {
+		return err
+	}
+	if processArgs.output != "" && !mapstore.ValidID(processArgs.output) {
+		return fmt.Errorf("output: %q: invalid map id", processArgs.output)
+	}
+	hm, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+	output := processArgs.output
+	if output == "" {
+		output = id + "-" + suffix(hm)
+	}
+	if _, err := store.Stat(output); err == nil {
+		if !processArgs.force {
+			log.Printf("%s exists\n", output)
+			return os.ErrExist
+		}
+		log.Printf("will overwrite %s\n", output)
+	}
+	if processArgs.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, processArgs.timeout)
+		defer cancel()
+	}
+	started := time.Now()
+	nm, step, err := change(ctx, hm)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("processing took longer than %v", processArgs.timeout)
+	} else if err != nil {
+		return err
+	}
+	nm.Metadata = hm.Metadata.Processed(step)
+
+	if err := store.Put(output, nm); err != nil {
+		return err
+	}
+	log.Printf("%s: %s\n", id, step)
+	log.Printf("created %s, elapsed %v\n", output, time.Now().Sub(started))
+	return nil
+}
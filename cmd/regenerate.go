@@ -0,0 +1,76 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/generators"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"github.com/mdhender/mapgen/pkg/mapstore"
+	"github.com/spf13/cobra"
+	"log"
+	"time"
+)
+
+var regenerateArgs struct {
+	dataType string
+	compress bool
+	force    bool
+	timeout  time.Duration
+}
+
+var regenerateCmd = &cobra.Command{
+	Use:   "regenerate id",
+	Short: "Recreate a map from its provenance",
+	Long: `Recreate a map using the generator, parameters, and seed recorded in an existing map.
+Legacy JSON maps do not record their provenance and can't be recreated.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := mapstore.NewFileStore(rootArgs.dataDir, heightmap.EncodeOptions{})
+		if err != nil {
+			return err
+		}
+		info, err := store.Stat(args[0])
+		if err != nil {
+			return err
+		}
+		meta := info.Metadata
+		if meta == nil || meta.Generator == "" {
+			return fmt.Errorf("%s: map does not record its provenance", args[0])
+		} else if len(meta.Processing) != 0 {
+			return fmt.Errorf("%s: map was processed after it was generated; regenerate %s instead", args[0], meta.ID())
+		}
+		log.Printf("%s: %s\n", args[0], meta.CommandLine())
+		if meta.Version != version {
+			log.Printf("%s: created by mapgen %s, this is %s\n", args[0], meta.Version, version)
+		}
+
+		g, ok := generators.Lookup(meta.Generator)
+		if !ok {
+			return fmt.Errorf("%q: unknown generator", meta.Generator)
+		}
+		params, err := generators.Validate(g.Parameters(), meta.Params)
+		if err != nil {
+			return err
+		}
+		opts, err := encodeOptions(regenerateArgs.dataType, regenerateArgs.compress)
+		if err != nil {
+			return err
+		}
+		return generateMap(cmd.Context(), g, params, meta.Seed, regenerateArgs.timeout, opts, regenerateArgs.force)
+	},
+}
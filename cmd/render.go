@@ -0,0 +1,85 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"github.com/mdhender/mapgen/pkg/mapstore"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"strings"
+)
+
+var renderArgs struct {
+	output    string
+	style     heightmap.Style
+	mode      string
+	transform string
+}
+
+var renderCmd = &cobra.Command{
+	Use:   "render id",
+	Short: "Draw a map as a PNG image",
+	Long: `Draw a map as a PNG image, applying a list of transforms first.
+
+Transforms are separated by "~" and the arguments of each transform by "_".
+For example, --transform rotate_90~shift_25_0 rotates the map and then shifts it.
+Use --mode hillshade or --mode shaded to light the terrain.
+The transforms are:
+
+  ` + strings.Join(heightmap.TransformUsage(), "\n  "),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pipeline, err := heightmap.ParsePipeline(renderArgs.transform)
+		if err != nil {
+			return fmt.Errorf("transform: %w", err)
+		}
+		style := renderArgs.style
+		if style.Mode, err = heightmap.ParseMode(renderArgs.mode); err != nil {
+			return fmt.Errorf("mode: %w", err)
+		} else if err = style.Validate(); err != nil {
+			return err
+		}
+		store, err := mapstore.NewFileStore(rootArgs.dataDir, heightmap.EncodeOptions{})
+		if err != nil {
+			return err
+		}
+		hm, err := store.Get(args[0])
+		if err != nil {
+			return err
+		}
+		if hm, err = pipeline.Apply(hm); err != nil {
+			return err
+		}
+		bb, err := style.Render(hm)
+		if err != nil {
+			return err
+		}
+
+		output := renderArgs.output
+		if output == "" {
+			output = args[0] + ".png"
+		}
+		if err := os.WriteFile(output, bb, 0644); err != nil {
+			return err
+		}
+		log.Printf("created %s\n", output)
+		return nil
+	},
+}
@@ -17,10 +17,22 @@
 package cmd
 
 import (
+	"context"
+	"github.com/mdhender/mapgen/pkg/generators"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"github.com/mdhender/mapgen/pkg/processors"
 	"github.com/spf13/cobra"
 	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"time"
 )
 
+var rootArgs struct {
+	dataDir string
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "mapgen",
 	Short: "mapgen is a fantasy map generator",
@@ -31,33 +43,75 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() {
+	rootCmd.PersistentFlags().StringVar(&rootArgs.dataDir, "data-dir", ".", "Directory for map files")
+
+	biomesCmd.Flags().IntVar(&biomesArgs.pctWater, "pct-water", heightmap.DefaultStyle.PctWater, "Percentage of the map to allocate to water")
+	rootCmd.AddCommand(biomesCmd)
+
 	colormapCmd.Flags().BoolVarP(&colormapArgs.consolidated, "consolidated", "c", false, "Show consolidated map")
 
 	rootCmd.AddCommand(colormapCmd)
 
-	generateFlatCmd.Flags().BoolVarP(&generateFlatArgs.force, "force", "f", false, "Overwrite any existing files")
-	generateFlatCmd.Flags().IntVarP(&generateFlatArgs.height, "height", "H", 640, "Height (in pixels) of map")
-	generateFlatCmd.Flags().IntVarP(&generateFlatArgs.iterations, "iterations", "i", 10_000, "Number of iterations")
-	generateFlatCmd.Flags().Int64VarP(&generateFlatArgs.seed, "seed", "s", 0, "Seed for generator")
-	generateFlatCmd.Flags().IntVarP(&generateFlatArgs.width, "width", "W", 1280, "Width (in pixels) of map")
-	generateFlatCmd.Flags().BoolVar(&generateFlatArgs.wrap, "wrap", false, "Wrap fractures")
-	if err := generateFlatCmd.MarkFlagRequired("seed"); err != nil {
-		log.Fatal(err)
+	for _, g := range generators.List() {
+		generateCmd.AddCommand(newGenerateCmd(g))
 	}
-	generateCmd.AddCommand(generateFlatCmd)
+	rootCmd.AddCommand(generateCmd)
 
-	generateOlssonCmd.Flags().BoolVarP(&generateOlssonArgs.force, "force", "f", false, "Overwrite any existing files")
-	generateOlssonCmd.Flags().IntVarP(&generateOlssonArgs.iterations, "iterations", "i", 10_000, "Number of iterations")
-	generateOlssonCmd.Flags().Int64VarP(&generateOlssonArgs.seed, "seed", "s", 0, "Seed for generator")
-	if err := generateOlssonCmd.MarkFlagRequired("seed"); err != nil {
-		log.Fatal(err)
+	hydrologyCmd.Flags().BoolVar(&hydrologyArgs.flood, "flood", false, "Fill basins to their spill points before finding lakes")
+	hydrologyCmd.Flags().StringVarP(&hydrologyArgs.output, "output", "o", "", "Name of the GeoJSON file (default is the id followed by -hydrology.geojson)")
+	hydrologyCmd.Flags().IntVar(&hydrologyArgs.pctWater, "pct-water", heightmap.DefaultStyle.PctWater, "Percentage of the map to allocate to water")
+	hydrologyCmd.Flags().IntVar(&hydrologyArgs.rivers, "rivers", 500, "Export rivers that drain at least this many pixels (0 for none)")
+	rootCmd.AddCommand(hydrologyCmd)
+
+	processCmd.PersistentFlags().BoolVar(&processArgs.compress, "compress", false, "Compress the elevation data")
+	processCmd.PersistentFlags().StringVar(&processArgs.dataType, "data-type", "float32", "Type used to store elevations (float32, float64, or uint16)")
+	processCmd.PersistentFlags().BoolVarP(&processArgs.force, "force", "f", false, "Overwrite any existing files")
+	processCmd.PersistentFlags().StringVarP(&processArgs.output, "output", "o", "", "Id of the new map (default is the id followed by the size of the new map or the name of the processor)")
+	processCmd.PersistentFlags().DurationVar(&processArgs.timeout, "timeout", 0, "Stop processing after this long (0 for no limit)")
+	processResizeCmd.Flags().IntVarP(&resizeArgs.height, "height", "H", 0, "Height (in pixels) of the new map")
+	processResizeCmd.Flags().StringVar(&resizeArgs.method, "method", "lanczos", "Interpolation (nearest, bilinear, bicubic, or lanczos)")
+	processResizeCmd.Flags().IntVarP(&resizeArgs.width, "width", "W", 0, "Width (in pixels) of the new map")
+	processCmd.AddCommand(processResizeCmd)
+	for _, p := range processors.List() {
+		processCmd.AddCommand(newProcessCmd(p))
 	}
-	generateCmd.AddCommand(generateOlssonCmd)
+	rootCmd.AddCommand(processCmd)
 
-	rootCmd.AddCommand(generateCmd)
+	regenerateCmd.Flags().BoolVar(&regenerateArgs.compress, "compress", false, "Compress the elevation data")
+	regenerateCmd.Flags().StringVar(&regenerateArgs.dataType, "data-type", "float32", "Type used to store elevations (float32, float64, or uint16)")
+	regenerateCmd.Flags().BoolVarP(&regenerateArgs.force, "force", "f", false, "Overwrite any existing files")
+	regenerateCmd.Flags().DurationVar(&regenerateArgs.timeout, "timeout", 0, "Stop the generator after this long (0 for no limit)")
+	rootCmd.AddCommand(regenerateCmd)
+
+	renderCmd.Flags().Float64Var(&renderArgs.style.Light.Altitude, "altitude", heightmap.DefaultLight.Altitude, "Angle (in degrees) of the light above the horizon")
+	renderCmd.Flags().Float64Var(&renderArgs.style.Light.Azimuth, "azimuth", heightmap.DefaultLight.Azimuth, "Direction (in degrees clockwise from north) the light comes from")
+	renderCmd.Flags().Float64Var(&renderArgs.style.Blend, "blend", heightmap.DefaultStyle.Blend, "How strongly the colors are shaded in shaded mode (0 to 1)")
+	renderCmd.Flags().Float64Var(&renderArgs.style.Light.Exaggeration, "exaggeration", heightmap.DefaultLight.Exaggeration, "Multiply the height of the terrain when shading")
+	renderCmd.Flags().BoolVar(&renderArgs.style.FalseColor, "false-color", false, "Draw layers such as slope in color instead of gray")
+	renderCmd.Flags().BoolVar(&renderArgs.style.Flood, "flood", false, "Fill basins to their spill points when drawing lakes")
+	renderCmd.Flags().BoolVar(&renderArgs.style.UseHSL, "hsl", false, "Use the HSL color map")
+	renderCmd.Flags().BoolVar(&renderArgs.style.Lakes, "lakes", false, "Draw lakes that don't reach the ocean")
+	renderCmd.Flags().StringVar(&renderArgs.mode, "mode", string(heightmap.ModeColor), "How to draw the map (color, hillshade, shaded, slope, aspect, profile-curvature, plan-curvature, roughness, tri, temperature, precipitation, or biome)")
+	renderCmd.Flags().BoolVar(&renderArgs.style.Light.MultiDirectional, "multi-directional", false, "Light the terrain from several directions")
+	renderCmd.Flags().IntVar(&renderArgs.style.PctIce, "pct-ice", heightmap.DefaultStyle.PctIce, "Percentage of the terrain to allocate to ice")
+	renderCmd.Flags().IntVar(&renderArgs.style.PctWater, "pct-water", heightmap.DefaultStyle.PctWater, "Percentage of the map to allocate to water")
+	renderCmd.Flags().StringVarP(&renderArgs.output, "output", "o", "", "Name of the image file (default is the id with a .png extension)")
+	renderCmd.Flags().IntVar(&renderArgs.style.Rivers, "rivers", 0, "Draw rivers that drain at least this many pixels (0 for none)")
+	renderCmd.Flags().StringVarP(&renderArgs.transform, "transform", "t", "", "Transforms to apply before drawing")
+	rootCmd.AddCommand(renderCmd)
 
+	serverCmd.Flags().IntVarP(&serverArgs.height, "height", "H", 640, "Default height (in pixels) of new maps")
+	serverCmd.Flags().Int64Var(&serverArgs.imageCache, "image-cache", 64, "Memory (in megabytes) used to cache rendered images")
+	serverCmd.Flags().IntVarP(&serverArgs.iterations, "iterations", "i", 10_000, "Default number of iterations for new maps")
+	serverCmd.Flags().Int64Var(&serverArgs.mapCache, "map-cache", 256, "Memory (in megabytes) used to cache decoded maps")
+	serverCmd.Flags().DurationVar(&serverArgs.maxGenerationTime, "max-generation-time", 5*time.Minute, "Stop generators that run longer than this (0 for no limit)")
+	serverCmd.Flags().IntVar(&serverArgs.maxHeight, "max-height", 4*1024, "Maximum height (in pixels) of new maps")
+	serverCmd.Flags().IntVar(&serverArgs.maxIterations, "max-iterations", 100_000, "Maximum number of iterations for new maps")
+	serverCmd.Flags().IntVar(&serverArgs.maxWidth, "max-width", 4*1024, "Maximum width (in pixels) of new maps")
 	serverCmd.Flags().StringVar(&serverArgs.secret, "secret", "tangy", "Secret for user access")
 	serverCmd.Flags().StringVar(&serverArgs.signingKey, "signing-key", "", "Signing key for server")
+	serverCmd.Flags().IntVarP(&serverArgs.width, "width", "W", 1280, "Default width (in pixels) of new maps")
+	serverCmd.Flags().IntVar(&serverArgs.workers, "workers", runtime.NumCPU(), "Number of maps to generate at the same time")
 	if err := serverCmd.MarkFlagRequired("signing-key"); err != nil {
 		log.Fatal(err)
 	}
@@ -66,7 +120,11 @@ func Execute() {
 
 	rootCmd.AddCommand(versionCmd)
 
-	if err := rootCmd.Execute(); err != nil {
+	// interrupting the program stops any running generator cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		log.Fatal(err)
 	}
 }
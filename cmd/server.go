@@ -22,11 +22,22 @@ import (
 	"github.com/spf13/cobra"
 	"log"
 	"net/http"
+	"time"
 )
 
 var serverArgs struct {
-	secret     string
-	signingKey string
+	secret        string
+	signingKey    string
+	height, width int
+	iterations    int
+	maxHeight     int
+	maxWidth      int
+	maxIterations int
+	workers       int
+	imageCache    int64 // megabytes
+	mapCache      int64 // megabytes
+	// maxGenerationTime is the longest a generator may run
+	maxGenerationTime time.Duration
 }
 
 var serverCmd = &cobra.Command{
@@ -47,6 +58,16 @@ var serverCmd = &cobra.Command{
 			server.WithRoot(".."),
 			server.WithTemplates("templates"),
 			server.WithPublic("public"),
+			server.WithVersion(version),
+			server.WithDataDir(rootArgs.dataDir),
+			server.WithMapSize(serverArgs.width, serverArgs.height),
+			server.WithIterations(serverArgs.iterations),
+			server.WithImageCacheSize(serverArgs.imageCache*1024*1024),
+			server.WithMapCacheSize(serverArgs.mapCache*1024*1024),
+			server.WithMaxMapSize(serverArgs.maxWidth, serverArgs.maxHeight),
+			server.WithMaxGenerationTime(serverArgs.maxGenerationTime),
+			server.WithMaxIterations(serverArgs.maxIterations),
+			server.WithWorkers(serverArgs.workers),
 		)
 		if err != nil {
 			return err
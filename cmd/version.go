@@ -21,11 +21,15 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// version is the version of mapgen.
+// It is recorded in the metadata of every map.
+const version = "v0.1.0"
+
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print the version number of mapgen",
 	Long:  `All software has versions. This is mapgens's`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("mapgen v0.1.0")
+		fmt.Println("mapgen " + version)
 	},
 }
@@ -0,0 +1,338 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package erosion wears down the terrain of a map.
+//
+// Every function returns a new map and leaves the original alone.
+// Functions that use randomness take a random source, so that the same
+// seed always erodes a map the same way.
+package erosion
+
+import (
+	"context"
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/generators"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"math"
+	"math/rand"
+)
+
+// HydraulicOptions are the settings for hydraulic erosion.
+// Slopes and sediment are measured as fractions of the range of elevations
+// of the map, so maps erode the same way whatever their MinZ and MaxZ.
+type HydraulicOptions struct {
+	// Droplets is the number of raindrops that fall on the map.
+	Droplets int
+	// Lifetime is the most steps a raindrop takes before it is done.
+	Lifetime int
+	// Inertia is how much a raindrop keeps going in the same direction
+	// instead of following the slope, from 0 to 1.
+	Inertia float64
+	// Capacity multiplies the amount of sediment a raindrop can carry.
+	// Faster raindrops with more water on steeper slopes carry more.
+	Capacity float64
+	// MinSlope keeps raindrops on nearly flat ground from dropping
+	// everything they carry.
+	MinSlope float64
+	// Deposition is the fraction of the extra sediment that a raindrop
+	// drops each step when it is carrying more than it can, from 0 to 1.
+	Deposition float64
+	// Erosion is the fraction of its unused capacity that a raindrop
+	// picks up each step, from 0 to 1.
+	Erosion float64
+	// Evaporation is the fraction of its water that a raindrop loses each step, from 0 to 1.
+	Evaporation float64
+	// Gravity speeds up raindrops going downhill.
+	Gravity float64
+	// Radius is the distance (in pixels) around a raindrop that it erodes.
+	Radius int
+}
+
+// DefaultHydraulic are settings that carve visible valleys into most maps.
+var DefaultHydraulic = HydraulicOptions{
+	Droplets:    100_000,
+	Lifetime:    30,
+	Inertia:     0.05,
+	Capacity:    4,
+	MinSlope:    0.01,
+	Deposition:  0.3,
+	Erosion:     0.3,
+	Evaporation: 0.01,
+	Gravity:     4,
+	Radius:      3,
+}
+
+// Validate returns an error if the options can't be used.
+func (o HydraulicOptions) Validate() error {
+	if o.Droplets < 0 {
+		return fmt.Errorf("droplets: must not be negative")
+	} else if o.Lifetime < 1 {
+		return fmt.Errorf("lifetime: must be at least 1")
+	} else if o.Radius < 1 {
+		return fmt.Errorf("radius: must be at least 1")
+	}
+	for _, f := range []struct {
+		name  string
+		value float64
+	}{
+		{"inertia", o.Inertia},
+		{"deposition", o.Deposition},
+		{"erosion", o.Erosion},
+		{"evaporation", o.Evaporation},
+	} {
+		if f.value < 0 || f.value > 1 {
+			return fmt.Errorf("%s: must be between 0 and 1", f.name)
+		}
+	}
+	if o.Capacity < 0 || o.MinSlope < 0 || o.Gravity < 0 {
+		return fmt.Errorf("capacity, min-slope, and gravity must not be negative")
+	}
+	return nil
+}
+
+// Hydraulic simulates rain falling on the map, running downhill,
+// and carrying soil from the slopes to the valleys.
+// Raindrops that run off the edge of a map that wraps come back on the
+// other side; on other edges, they leave the map.
+// Progress is reported as the number of raindrops.
+func Hydraulic(ctx context.Context, hm *heightmap.Map, opts HydraulicOptions, rnd *rand.Rand, progress generators.Progress) (*heightmap.Map, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	t := newTerrain(hm)
+	brush := newBrush(opts.Radius)
+
+	every := generators.Interval(opts.Droplets)
+	for n := 0; n < opts.Droplets; n++ {
+		if n%every == 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			default:
+			}
+			progress.Report(n, opts.Droplets)
+		}
+		t.droplet(rnd.Float64()*float64(t.maxx), rnd.Float64()*float64(t.maxy), opts, brush)
+	}
+	progress.Report(opts.Droplets, opts.Droplets)
+	return t.result(), nil
+}
+
+// terrain is a copy of the elevations of a map that is eroded in place.
+type terrain struct {
+	hm           *heightmap.Map
+	z            [][]float64
+	maxx, maxy   int
+	wrapX, wrapY bool
+	// relief is the range of elevations of the map, or 1 if the map is flat.
+	relief float64
+}
+
+func newTerrain(hm *heightmap.Map) *terrain {
+	t := &terrain{hm: hm, maxx: len(hm.Data), maxy: len(hm.Data[0]), wrapX: hm.WrapX, wrapY: hm.WrapY, relief: 1}
+	if hm.MaxZ > hm.MinZ {
+		t.relief = hm.MaxZ - hm.MinZ
+	}
+	t.z = make([][]float64, t.maxx)
+	for x := range t.z {
+		t.z[x] = append([]float64(nil), hm.Data[x]...)
+	}
+	return t
+}
+
+// result returns a copy of the original map with the eroded elevations.
+// Elevations are kept in the range of the original map.
+func (t *terrain) result() *heightmap.Map {
+	nm := t.hm.Copy()
+	nm.Colors = nil
+	for x := range t.z {
+		for y, z := range t.z[x] {
+			nm.Data[x][y] = math.Max(t.hm.MinZ, math.Min(t.hm.MaxZ, z))
+		}
+	}
+	return nm
+}
+
+// index returns x and y wrapped onto the map.
+// Ok is false if they are off an edge that doesn't wrap.
+func (t *terrain) index(x, y int) (int, int, bool) {
+	if t.wrapX {
+		if x %= t.maxx; x < 0 {
+			x += t.maxx
+		}
+	}
+	if t.wrapY {
+		if y %= t.maxy; y < 0 {
+			y += t.maxy
+		}
+	}
+	return x, y, 0 <= x && x < t.maxx && 0 <= y && y < t.maxy
+}
+
+// at returns the elevation at the pixel, or at the nearest pixel on the
+// edge if it is off an edge that doesn't wrap.
+func (t *terrain) at(x, y int) float64 {
+	x, y, _ = t.index(x, y)
+	if x < 0 {
+		x = 0
+	} else if x >= t.maxx {
+		x = t.maxx - 1
+	}
+	if y < 0 {
+		y = 0
+	} else if y >= t.maxy {
+		y = t.maxy - 1
+	}
+	return t.z[x][y]
+}
+
+// sample returns the elevation and the gradient at a point inside the cell
+// whose top left corner is x, y; u and v are the offsets into the cell.
+func (t *terrain) sample(x, y int, u, v float64) (z, gx, gy float64) {
+	nw, ne, sw, se := t.at(x, y), t.at(x+1, y), t.at(x, y+1), t.at(x+1, y+1)
+	gx = (ne-nw)*(1-v) + (se-sw)*v
+	gy = (sw-nw)*(1-u) + (se-ne)*u
+	z = nw*(1-u)*(1-v) + ne*u*(1-v) + sw*(1-u)*v + se*u*v
+	return z, gx, gy
+}
+
+// move returns the point wrapped onto the map.
+// Ok is false if the point has left the map.
+func (t *terrain) move(x, y float64) (float64, float64, bool) {
+	if t.wrapX {
+		if x = math.Mod(x, float64(t.maxx)); x < 0 {
+			x += float64(t.maxx)
+		}
+		if x >= float64(t.maxx) {
+			x = 0
+		}
+	} else if x < 0 || x >= float64(t.maxx-1) {
+		return x, y, false
+	}
+	if t.wrapY {
+		if y = math.Mod(y, float64(t.maxy)); y < 0 {
+			y += float64(t.maxy)
+		}
+		if y >= float64(t.maxy) {
+			y = 0
+		}
+	} else if y < 0 || y >= float64(t.maxy-1) {
+		return x, y, false
+	}
+	return x, y, true
+}
+
+// deposit adds sediment to the corners of the cell, weighted by how close the point is to each.
+func (t *terrain) deposit(x, y int, u, v, amount float64) {
+	for _, c := range []struct {
+		dx, dy int
+		w      float64
+	}{{0, 0, (1 - u) * (1 - v)}, {1, 0, u * (1 - v)}, {0, 1, (1 - u) * v}, {1, 1, u * v}} {
+		if px, py, ok := t.index(x+c.dx, y+c.dy); ok {
+			t.z[px][py] += amount * c.w
+		}
+	}
+}
+
+// brushCell is a pixel eroded by a raindrop, relative to the raindrop.
+type brushCell struct {
+	dx, dy int
+	w      float64
+}
+
+// newBrush returns the pixels within the radius, weighted so that
+// the closer pixels are eroded more and the weights add up to 1.
+func newBrush(radius int) []brushCell {
+	var cells []brushCell
+	var sum float64
+	for dx := -radius; dx <= radius; dx++ {
+		for dy := -radius; dy <= radius; dy++ {
+			if w := float64(radius) - math.Hypot(float64(dx), float64(dy)); w > 0 {
+				cells = append(cells, brushCell{dx: dx, dy: dy, w: w})
+				sum += w
+			}
+		}
+	}
+	for n := range cells {
+		cells[n].w /= sum
+	}
+	return cells
+}
+
+// erode removes up to amount of soil around the pixel and returns how much was removed.
+// Pixels are never eroded below the lowest elevation of the map.
+func (t *terrain) erode(x, y int, amount float64, brush []brushCell) float64 {
+	var removed float64
+	for _, c := range brush {
+		px, py, ok := t.index(x+c.dx, y+c.dy)
+		if !ok {
+			continue
+		}
+		dz := math.Min(amount*c.w, t.z[px][py]-t.hm.MinZ)
+		if dz > 0 {
+			t.z[px][py] -= dz
+			removed += dz
+		}
+	}
+	return removed
+}
+
+// droplet follows a raindrop from where it lands until it evaporates,
+// stops in a pit, or runs off the map.
+func (t *terrain) droplet(px, py float64, o HydraulicOptions, brush []brushCell) {
+	var dx, dy, sediment float64
+	speed, water := 1.0, 1.0
+	for step := 0; step < o.Lifetime; step++ {
+		x, y := int(px), int(py)
+		u, v := px-float64(x), py-float64(y)
+		z, gx, gy := t.sample(x, y, u, v)
+
+		// turn downhill, keeping some of the old direction
+		gx, gy = gx/t.relief, gy/t.relief
+		dx, dy = dx*o.Inertia-gx*(1-o.Inertia), dy*o.Inertia-gy*(1-o.Inertia)
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			break
+		}
+		dx, dy = dx/length, dy/length
+		nx, ny, ok := t.move(px+dx, py+dy)
+		if !ok {
+			break
+		}
+		nz, _, _ := t.sample(int(nx), int(ny), nx-math.Floor(nx), ny-math.Floor(ny))
+		dz := nz - z
+		slope := dz / t.relief
+
+		capacity := math.Max(-slope, o.MinSlope) * speed * water * o.Capacity * t.relief
+		if sediment > capacity || dz > 0 {
+			// going uphill fills the pit behind the raindrop;
+			// otherwise, drop some of what it can't carry
+			amount := (sediment - capacity) * o.Deposition
+			if dz > 0 {
+				amount = math.Min(dz, sediment)
+			}
+			sediment -= amount
+			t.deposit(x, y, u, v, amount)
+		} else {
+			// never dig deeper than the drop to the next point
+			sediment += t.erode(x, y, math.Min((capacity-sediment)*o.Erosion, -dz), brush)
+		}
+
+		speed = math.Sqrt(math.Max(0, speed*speed-slope*o.Gravity))
+		water *= 1 - o.Evaporation
+		px, py = nx, ny
+	}
+}
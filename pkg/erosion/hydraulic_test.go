@@ -0,0 +1,109 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package erosion
+
+import (
+	"context"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// testMap returns a small, rough map with elevations from minz to maxz.
+func testMap(minz, maxz float64) *heightmap.Map {
+	hm := &heightmap.Map{MinZ: minz, MaxZ: maxz, WrapX: true, Data: make([][]float64, 24)}
+	for x := range hm.Data {
+		hm.Data[x] = make([]float64, 16)
+		for y := range hm.Data[x] {
+			// from 0 to 1: a ridge down the middle with bumps on it
+			z := (2 + math.Sin(float64(x)*0.7) + math.Cos(float64(y)*0.9)) / 4 * (1 - math.Abs(float64(y)-7.5)/8)
+			hm.Data[x][y] = minz + z*(maxz-minz)
+		}
+	}
+	return hm
+}
+
+// hydraulic erodes the map with the seed and fails the test on an error.
+func hydraulic(t *testing.T, hm *heightmap.Map, seed int64) *heightmap.Map {
+	opts := DefaultHydraulic
+	opts.Droplets, opts.Radius = 2_000, 2
+	nm, err := Hydraulic(context.Background(), hm, opts, rand.New(rand.NewSource(seed)), nil)
+	if err != nil {
+		t.Fatalf("seed %d: unexpected error %v", seed, err)
+	}
+	return nm
+}
+
+func TestHydraulicIsDeterministic(t *testing.T) {
+	original := testMap(0, 1)
+	first, again := hydraulic(t, testMap(0, 1), 1), hydraulic(t, testMap(0, 1), 1)
+	if !reflect.DeepEqual(first.Data, again.Data) {
+		t.Errorf("the same seed eroded the map differently")
+	}
+	if reflect.DeepEqual(first.Data, original.Data) {
+		t.Errorf("the map was not eroded")
+	}
+	if other := hydraulic(t, testMap(0, 1), 2); reflect.DeepEqual(first.Data, other.Data) {
+		t.Errorf("different seeds eroded the map the same way")
+	}
+}
+
+func TestHydraulicLeavesOriginal(t *testing.T) {
+	hm := testMap(0, 1)
+	hydraulic(t, hm, 1)
+	if !reflect.DeepEqual(hm.Data, testMap(0, 1).Data) {
+		t.Errorf("changed the original map")
+	}
+}
+
+func TestHydraulicRelief(t *testing.T) {
+	want := hydraulic(t, testMap(0, 1), 3)
+
+	// scaling by a power of two is exact, so the map erodes to exactly the same shape
+	for _, maxz := range []float64{128, 0.25} {
+		got := hydraulic(t, testMap(0, maxz), 3)
+		for x := range want.Data {
+			for y, z := range want.Data[x] {
+				if e := got.Data[x][y] / maxz; e != z {
+					t.Fatalf("0 to %g: %d %d: got %g, want %g", maxz, x, y, e, z)
+				}
+			}
+		}
+	}
+
+	// otherwise, rounding sends some raindrops down other paths,
+	// but about the same amount of soil is moved
+	moved := func(hm *heightmap.Map, minz, maxz float64) float64 {
+		original := testMap(minz, maxz)
+		var sum float64
+		for x := range hm.Data {
+			for y := range hm.Data[x] {
+				sum += math.Abs(hm.Data[x][y]-original.Data[x][y]) / (maxz - minz)
+			}
+		}
+		return sum
+	}
+	expected := moved(want, 0, 1)
+	for _, r := range []struct{ minz, maxz float64 }{{0, 100}, {-50, 250}, {0.25, 0.5}} {
+		got := moved(hydraulic(t, testMap(r.minz, r.maxz), 3), r.minz, r.maxz)
+		if math.Abs(got-expected) > 0.05*expected {
+			t.Errorf("%g to %g: moved %g, want about %g", r.minz, r.maxz, got, expected)
+		}
+	}
+}
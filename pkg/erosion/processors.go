@@ -0,0 +1,130 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package erosion
+
+import (
+	"context"
+	"github.com/mdhender/mapgen/pkg/generators"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"github.com/mdhender/mapgen/pkg/processors"
+	"math/rand"
+	"strconv"
+)
+
+func init() {
+	processors.Register(HydraulicProcessor{})
+	processors.Register(ThermalProcessor{})
+}
+
+// HydraulicProcessor implements processors.Processor for Hydraulic.
+type HydraulicProcessor struct{}
+
+func (HydraulicProcessor) Name() string {
+	return "erode-hydraulic"
+}
+
+func (HydraulicProcessor) Description() string {
+	return "Carve valleys with rain that carries soil downhill"
+}
+
+func (HydraulicProcessor) Parameters() []generators.Parameter {
+	d := DefaultHydraulic
+	return []generators.Parameter{
+		{Name: "droplets", Usage: "Number of raindrops", Kind: generators.Int, Default: strconv.Itoa(d.Droplets), Min: 0, Max: 100_000_000},
+		{Name: "lifetime", Usage: "Most steps a raindrop takes", Kind: generators.Int, Default: strconv.Itoa(d.Lifetime), Min: 1, Max: 1_000},
+		{Name: "inertia", Usage: "How much raindrops keep their direction (0 to 1)", Kind: generators.Float, Default: formatFloat(d.Inertia), Min: 0, Max: 1},
+		{Name: "capacity", Usage: "How much sediment raindrops carry", Kind: generators.Float, Default: formatFloat(d.Capacity), Min: 0, Max: 100},
+		{Name: "min-slope", Usage: "Slope used for the capacity of raindrops on flat ground", Kind: generators.Float, Default: formatFloat(d.MinSlope), Min: 0, Max: 1},
+		{Name: "deposition", Usage: "Fraction of extra sediment dropped each step (0 to 1)", Kind: generators.Float, Default: formatFloat(d.Deposition), Min: 0, Max: 1},
+		{Name: "erosion", Usage: "Fraction of unused capacity picked up each step (0 to 1)", Kind: generators.Float, Default: formatFloat(d.Erosion), Min: 0, Max: 1},
+		{Name: "evaporation", Usage: "Fraction of water lost each step (0 to 1)", Kind: generators.Float, Default: formatFloat(d.Evaporation), Min: 0, Max: 1},
+		{Name: "gravity", Usage: "How fast raindrops speed up going downhill", Kind: generators.Float, Default: formatFloat(d.Gravity), Min: 0, Max: 100},
+		{Name: "radius", Usage: "Radius (in pixels) eroded around each raindrop", Kind: generators.Int, Default: strconv.Itoa(d.Radius), Min: 1, Max: 16},
+	}
+}
+
+func (HydraulicProcessor) Process(ctx context.Context, hm *heightmap.Map, params generators.Params, rnd *rand.Rand, progress generators.Progress) (*heightmap.Map, error) {
+	var opts HydraulicOptions
+	var err error
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{
+		{"droplets", &opts.Droplets},
+		{"lifetime", &opts.Lifetime},
+		{"radius", &opts.Radius},
+	} {
+		if *p.value, err = params.Int(p.name); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		value *float64
+	}{
+		{"inertia", &opts.Inertia},
+		{"capacity", &opts.Capacity},
+		{"min-slope", &opts.MinSlope},
+		{"deposition", &opts.Deposition},
+		{"erosion", &opts.Erosion},
+		{"evaporation", &opts.Evaporation},
+		{"gravity", &opts.Gravity},
+	} {
+		if *p.value, err = params.Float(p.name); err != nil {
+			return nil, err
+		}
+	}
+	return Hydraulic(ctx, hm, opts, rnd, progress)
+}
+
+// ThermalProcessor implements processors.Processor for Thermal.
+type ThermalProcessor struct{}
+
+func (ThermalProcessor) Name() string {
+	return "erode-thermal"
+}
+
+func (ThermalProcessor) Description() string {
+	return "Crumble cliffs and steps that are steeper than the talus angle"
+}
+
+func (ThermalProcessor) Parameters() []generators.Parameter {
+	d := DefaultThermal
+	return []generators.Parameter{
+		{Name: "iterations", Usage: "Number of times material is moved downhill", Kind: generators.Int, Default: strconv.Itoa(d.Iterations), Min: 0, Max: 10_000},
+		{Name: "talus", Usage: "Steepest slope (in degrees) that doesn't crumble", Kind: generators.Float, Default: formatFloat(d.Talus), Min: 1, Max: 89},
+		{Name: "rate", Usage: "Fraction of the extra material moved each iteration (0 to 1)", Kind: generators.Float, Default: formatFloat(d.Rate), Min: 0, Max: 1},
+	}
+}
+
+// Process runs Thermal, which doesn't use rnd; every seed gives the same map.
+func (ThermalProcessor) Process(ctx context.Context, hm *heightmap.Map, params generators.Params, _ *rand.Rand, progress generators.Progress) (*heightmap.Map, error) {
+	var opts ThermalOptions
+	var err error
+	if opts.Iterations, err = params.Int("iterations"); err != nil {
+		return nil, err
+	} else if opts.Talus, err = params.Float("talus"); err != nil {
+		return nil, err
+	} else if opts.Rate, err = params.Float("rate"); err != nil {
+		return nil, err
+	}
+	return Thermal(ctx, hm, opts, progress)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,149 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package erosion
+
+import (
+	"context"
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/generators"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"math"
+)
+
+// ThermalOptions are the settings for thermal erosion.
+type ThermalOptions struct {
+	// Iterations is the number of times that material is moved downhill.
+	Iterations int
+	// Talus is the steepest slope, in degrees, that doesn't crumble.
+	// Slopes are measured with the DefaultScale of the map,
+	// the same way as the slope layer.
+	Talus float64
+	// Rate is the fraction of the material above the talus slope
+	// that is moved each iteration, from 0 to 1.
+	Rate float64
+}
+
+// DefaultThermal are settings that soften cliffs and steps without
+// flattening the rest of the map.
+var DefaultThermal = ThermalOptions{
+	Iterations: 50,
+	Talus:      25,
+	Rate:       0.5,
+}
+
+// Validate returns an error if the options can't be used.
+func (o ThermalOptions) Validate() error {
+	if o.Iterations < 0 {
+		return fmt.Errorf("iterations: must not be negative")
+	} else if o.Talus <= 0 || o.Talus >= 90 {
+		return fmt.Errorf("talus: must be greater than 0 and less than 90 degrees")
+	} else if o.Rate < 0 || o.Rate > 1 {
+		return fmt.Errorf("rate: must be between 0 and 1")
+	}
+	return nil
+}
+
+// neighbors are the offsets to the eight pixels around a pixel and the distance to each.
+var neighbors = [8]struct {
+	dx, dy int
+	d      float64
+}{
+	{-1, -1, math.Sqrt2}, {0, -1, 1}, {1, -1, math.Sqrt2},
+	{-1, 0, 1}, {1, 0, 1},
+	{-1, 1, math.Sqrt2}, {0, 1, 1}, {1, 1, math.Sqrt2},
+}
+
+// Thermal crumbles slopes that are steeper than the talus angle, moving
+// material from the top of the slope to the bottom until it settles.
+// It softens cliffs and steps, such as the rings left by the flat generator
+// and the fault lines left by the Olsson generator.
+// Material moves across the edges of a map that wraps; other edges are walls.
+// No material is lost, and the result doesn't depend on the order the pixels are visited.
+// Progress is reported as the number of iterations.
+func Thermal(ctx context.Context, hm *heightmap.Map, opts ThermalOptions, progress generators.Progress) (*heightmap.Map, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	t := newTerrain(hm)
+
+	// the difference in elevation between neighbors at the talus slope
+	sc := hm.DefaultScale()
+	perPixel := math.Tan(opts.Talus*math.Pi/180) * sc.Pixel / sc.Relief
+	if hm.MaxZ > hm.MinZ {
+		perPixel *= hm.MaxZ - hm.MinZ
+	}
+	var talus [len(neighbors)]float64
+	for n, nb := range neighbors {
+		talus[n] = perPixel * nb.d
+	}
+
+	delta := make([][]float64, t.maxx)
+	for x := range delta {
+		delta[x] = make([]float64, t.maxy)
+	}
+	var excess [len(neighbors)]float64
+	every := generators.Interval(opts.Iterations)
+	for i := 0; i < opts.Iterations; i++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		if i%every == 0 {
+			progress.Report(i, opts.Iterations)
+		}
+
+		for x := 0; x < t.maxx; x++ {
+			for y := 0; y < t.maxy; y++ {
+				z := t.z[x][y]
+				var sum, most float64
+				for n, nb := range neighbors {
+					excess[n] = 0
+					if px, py, ok := t.index(x+nb.dx, y+nb.dy); ok {
+						if e := z - t.z[px][py] - talus[n]; e > 0 {
+							excess[n] = e
+							sum += e
+							most = math.Max(most, e)
+						}
+					}
+				}
+				if sum == 0 {
+					continue
+				}
+				// moving half of the largest excess levels that pair of pixels;
+				// moving more would make the pixel lower than its neighbor
+				moved := opts.Rate * most / 2
+				delta[x][y] -= moved
+				for n, nb := range neighbors {
+					if excess[n] > 0 {
+						px, py, _ := t.index(x+nb.dx, y+nb.dy)
+						delta[px][py] += moved * excess[n] / sum
+					}
+				}
+			}
+		}
+
+		for x := range delta {
+			for y, dz := range delta[x] {
+				t.z[x][y] += dz
+				delta[x][y] = 0
+			}
+		}
+	}
+	progress.Report(opts.Iterations, opts.Iterations)
+	return t.result(), nil
+}
@@ -17,11 +17,59 @@
 package flat
 
 import (
+	"context"
+	"github.com/mdhender/mapgen/pkg/generators"
 	"github.com/mdhender/mapgen/pkg/heightmap"
 	"math/rand"
 )
 
-func Generate(maxX, maxY, iterations int, wrap bool, rnd *rand.Rand) *heightmap.Map {
+func init() {
+	generators.Register(Generator{})
+}
+
+// Generator implements generators.Generator for flat maps.
+type Generator struct{}
+
+func (Generator) Name() string {
+	return "flat"
+}
+
+func (Generator) Description() string {
+	return "Generate a flat map using impact fractures"
+}
+
+func (Generator) Parameters() []generators.Parameter {
+	return []generators.Parameter{
+		{Name: "width", Shorthand: "W", Usage: "Width (in pixels) of map", Kind: generators.Int, Default: "1280", Min: 64, Max: 16 * 1024},
+		{Name: "height", Shorthand: "H", Usage: "Height (in pixels) of map", Kind: generators.Int, Default: "640", Min: 64, Max: 16 * 1024},
+		{Name: "iterations", Shorthand: "i", Usage: "Number of iterations", Kind: generators.Int, Default: "10000", Min: 0, Max: 10_000_000},
+		{Name: "wrap", Usage: "Wrap fractures", Kind: generators.Bool, Default: "false"},
+	}
+}
+
+func (Generator) Generate(ctx context.Context, params generators.Params, rnd *rand.Rand, progress generators.Progress) (*heightmap.Map, error) {
+	width, err := params.Int("width")
+	if err != nil {
+		return nil, err
+	}
+	height, err := params.Int("height")
+	if err != nil {
+		return nil, err
+	}
+	iterations, err := params.Int("iterations")
+	if err != nil {
+		return nil, err
+	}
+	wrap, err := params.Bool("wrap")
+	if err != nil {
+		return nil, err
+	}
+	return Generate(ctx, width, height, iterations, wrap, rnd, progress)
+}
+
+// Generate returns a new map.
+// It stops early if the context is canceled.
+func Generate(ctx context.Context, maxX, maxY, iterations int, wrap bool, rnd *rand.Rand, progress generators.Progress) (*heightmap.Map, error) {
 	data := make([]int, maxX*maxY, maxX*maxY)
 	xy := make([][]int, maxX, maxX)
 	for x := 0; x < maxX; x++ {
@@ -35,7 +83,17 @@ func Generate(maxX, maxY, iterations int, wrap bool, rnd *rand.Rand) *heightmap.
 		maxR = maxX / 2
 	}
 
+	total, interval := iterations, generators.Interval(iterations)
 	for iterations > 0 {
+		if done := total - iterations; done%interval == 0 {
+			progress.Report(done, total)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
 		// decide the amount that we're going to raise or lower
 		var bump int
 		switch rnd.Intn(2) {
@@ -97,6 +155,9 @@ func Generate(maxX, maxY, iterations int, wrap bool, rnd *rand.Rand) *heightmap.
 
 		iterations--
 	}
+	progress.Report(total, total)
 
-	return heightmap.FromArrayOfInt(xy, heightmap.XYOrientation)
+	hm := heightmap.FromArrayOfInt(xy, heightmap.XYOrientation)
+	hm.WrapX, hm.WrapY = wrap, wrap
+	return hm, nil
 }
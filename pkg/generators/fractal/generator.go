@@ -17,21 +17,96 @@
 package fractal
 
 import (
+	"context"
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/generators"
 	"github.com/mdhender/mapgen/pkg/heightmap"
 	"math"
 	"math/rand"
 )
 
-func Generate(iterations int, rnd *rand.Rand) *heightmap.Map {
+func init() {
+	generators.Register(Generator{})
+}
+
+// Generator implements generators.Generator for diamond-square fractal maps.
+type Generator struct{}
+
+func (Generator) Name() string {
+	return "fractal"
+}
+
+func (Generator) Description() string {
+	return "Generate a fractal map using the diamond-square algorithm"
+}
+
+func (Generator) Parameters() []generators.Parameter {
+	return []generators.Parameter{
+		{Name: "exponent", Shorthand: "e", Usage: "Grid size is 2^exponent + 1 points on each side", Kind: generators.Int, Default: "10", Min: 1, Max: 12},
+		{Name: "roughness", Shorthand: "r", Usage: "Hurst exponent; larger values give smoother terrain", Kind: generators.Float, Default: "0.001", Min: 0, Max: 2},
+		{Name: "corners", Usage: "Initial elevation of the corners, or \"random\"", Kind: generators.String, Default: "random"},
+		{Name: "height-scale", Usage: "Scale of the random displacements", Kind: generators.Float, Default: "1", Min: 0.001, Max: 1000},
+		{Name: "width", Shorthand: "W", Usage: "Width (in pixels) of map, tiling or cropping the grid; 0 uses the grid size", Kind: generators.Int, Default: "0", Min: 0, Max: 16 * 1024},
+		{Name: "height", Shorthand: "H", Usage: "Height (in pixels) of map, tiling or cropping the grid; 0 uses the grid size", Kind: generators.Int, Default: "0", Min: 0, Max: 16 * 1024},
+	}
+}
+
+func (Generator) Generate(ctx context.Context, params generators.Params, rnd *rand.Rand, progress generators.Progress) (*heightmap.Map, error) {
+	var opts Options
+	var err error
+	if opts.Exponent, err = params.Int("exponent"); err != nil {
+		return nil, err
+	} else if opts.Roughness, err = params.Float("roughness"); err != nil {
+		return nil, err
+	} else if opts.HeightScale, err = params.Float("height-scale"); err != nil {
+		return nil, err
+	} else if opts.Width, err = params.Int("width"); err != nil {
+		return nil, err
+	} else if opts.Height, err = params.Int("height"); err != nil {
+		return nil, err
+	}
+	if corners := params.String("corners"); corners == "random" {
+		opts.RandomCorners = true
+	} else if opts.Corners, err = params.Float("corners"); err != nil {
+		return nil, fmt.Errorf("\"corners\": must be a number or \"random\"")
+	}
+	return Generate(ctx, opts, rnd, progress)
+}
+
+// Options controls the diamond-square algorithm.
+type Options struct {
+	// Exponent sets the grid size to 2^Exponent + 1 points on each side.
+	Exponent int
+	// Roughness is the Hurst exponent.
+	// The random displacement is scaled by 2^-Roughness at each step,
+	// so larger values give smoother terrain.
+	Roughness float64
+	// HeightScale scales the random displacements.
+	HeightScale float64
+	// Corners is the initial elevation of the four corners.
+	// It is ignored if RandomCorners is set.
+	Corners       float64
+	RandomCorners bool
+	// Width and Height are the size of the final map.
+	// The grid wraps seamlessly, so it is tiled when the map is larger
+	// than the grid and cropped when the map is smaller.
+	// Zero means use the grid size.
+	Width, Height int
+}
+
+// Generate returns a new map.
+// Progress is reported as the number of passes of the diamond-square
+// algorithm completed; each pass halves the stride.
+// It stops early if the context is canceled.
+func Generate(ctx context.Context, opts Options, rnd *rand.Rand, progress generators.Progress) (*heightmap.Map, error) {
 	//started := time.Now()
 
-	iterations = 10
-	length := 1 << iterations
-	//log.Printf("fractal: iterations %6d length %8d\n", iterations, length)
+	length := 1 << opts.Exponent
+	//log.Printf("fractal: exponent %6d length %8d\n", opts.Exponent, length)
 	g := &grid{
 		maxx: length,
 		maxy: length,
-		h:    math.Pow(2, -0.001),
+		h:    math.Pow(2, -opts.Roughness),
 		rnd:  rnd,
 	}
 
@@ -46,14 +121,48 @@ func Generate(iterations int, rnd *rand.Rand) *heightmap.Map {
 	//g.xy[g.maxx][g.maxy] = g.xy[0][0]
 	//g.fracture(length/2, length/2, length/2, 1)
 
-	g.fa = make([]float64, (g.maxx+1)*(g.maxx+1), (g.maxy+1)*(g.maxy+1))
-	g.fill(1)
+	g.fa = make([]float64, (g.maxx+1)*(g.maxy+1), (g.maxx+1)*(g.maxy+1))
+	corners := opts.Corners
+	if opts.RandomCorners {
+		corners = g.randnum(-1, 1)
+	}
+	if err := g.fill(ctx, opts.HeightScale, corners, progress); err != nil {
+		return nil, err
+	}
 
-	//log.Printf("fractal: iterations %6d length %6d elapsed %v\n", iterations, length, time.Now().Sub(started))
-	return heightmap.FromSlice(g.fa, g.maxx+1, g.maxy+1, heightmap.XYOrientation, false)
+	//log.Printf("fractal: exponent %6d length %6d elapsed %v\n", opts.Exponent, length, time.Now().Sub(started))
+	width, height := opts.Width, opts.Height
+	if width == 0 {
+		width = g.maxx + 1
+	}
+	if height == 0 {
+		height = g.maxy + 1
+	}
+	if width == g.maxx+1 && height == g.maxy+1 {
+		return heightmap.FromSlice(g.fa, g.maxx+1, g.maxy+1, heightmap.XYOrientation, false), nil
+	}
+	hm := heightmap.FromSlice(g.tile(width, height), width, height, heightmap.XYOrientation, false)
+	// the map is seamless along an axis that holds a whole number of tiles
+	hm.WrapX, hm.WrapY = width%g.maxx == 0, height%g.maxy == 0
+	return hm, nil
 	//return heightmap.FromArray(g.xy, heightmap.XYOrientation, false)
 }
 
+// tile returns a width x height slice of the grid.
+// The last row and column of the grid duplicate the first, so the
+// grid repeats every maxx (or maxy) points.
+func (g *grid) tile(width, height int) []float64 {
+	size := g.maxy + 1
+	pixels := make([]float64, width*height)
+	for x := 0; x < width; x++ {
+		i := x % g.maxx
+		for y := 0; y < height; y++ {
+			pixels[x*height+y] = g.fa[(i*size)+y%g.maxy]
+		}
+	}
+	return pixels
+}
+
 type grid struct {
 	maxx, maxy int
 	fa         []float64
@@ -119,8 +228,9 @@ func (g *grid) avgSquareVals(i, j, stride, size int) float64 {
 
 /*
  * fill - Use the diamond-square algorithm to tessellate a grid of float values into a fractal height map.
+ * Returns ctx.Err() if the context is canceled before the grid is filled.
  */
-func (g *grid) fill(heightScale float64) {
+func (g *grid) fill(ctx context.Context, heightScale, corners float64, progress generators.Progress) error {
 	/* subSize is the dimension of the array in terms of connected line segments,
 	   while size is the dimension in terms of number of vertices. */
 	subSize, size := g.maxx, g.maxx+1
@@ -152,7 +262,7 @@ func (g *grid) fill(heightScale float64) {
 	   We want the four corners of the array to have the same point.
 	   This will allow us to tile the arrays next to each other such that they join seamlessly. */
 
-	g.fa[(0*size)+0] = g.randnum(-1, 1)
+	g.fa[(0*size)+0] = corners
 	g.fa[(subSize*size)+0] = g.fa[(0*size)+0]
 	g.fa[(subSize*size)+subSize] = g.fa[(0*size)+0]
 	g.fa[(0*size)+subSize] = g.fa[(0*size)+0]
@@ -161,7 +271,13 @@ func (g *grid) fill(heightScale float64) {
 	We loop over stride, which gets cut in half at the bottom of the loop.
 	Since it's an int, eventually division by 2 will produce a zero result, terminating the loop.
 	*/
+	passes := 0
 	for stride := subSize / 2; stride != 0; stride = stride / 2 {
+		passes++
+	}
+	for pass, stride := 0, subSize/2; stride != 0; pass, stride = pass+1, stride/2 {
+		progress.Report(pass, passes)
+
 		/* Take the existing "square" data and produce "diamond"
 		   data. On the first pass through with a 4x4 matrix, the
 		   existing data is shown as "X"s, and we need to generate the
@@ -210,6 +326,9 @@ func (g *grid) fill(heightScale float64) {
 		*/
 		oddline := false
 		for i := 0; i < subSize; i += stride {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			oddline = !oddline
 			for j := 0; j < subSize; j += stride {
 				if oddline && j == 0 {
@@ -236,6 +355,8 @@ func (g *grid) fill(heightScale float64) {
 		/* reduce random number range. */
 		scale *= ratio
 	}
+	progress.Report(passes, passes)
+	return nil
 }
 
 // x, y is the center point
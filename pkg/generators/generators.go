@@ -0,0 +1,89 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package generators defines the interface that all map generators implement
+// and a registry that the command line and the web server use to find them.
+//
+// A generator registers itself from an init function in its own package:
+//
+//	func init() {
+//		generators.Register(Generator{})
+//	}
+//
+// Programs that want the generator must import the package for its side effects.
+package generators
+
+import (
+	"context"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"math/rand"
+)
+
+// Generator is the interface implemented by every map generator.
+type Generator interface {
+	// Name is the unique name of the generator.
+	// It is used on the command line and in forms, so keep it short and lowercase.
+	Name() string
+	// Description is a one line summary of the generator.
+	Description() string
+	// Parameters is the schema for the parameters accepted by Generate.
+	Parameters() []Parameter
+	// Generate creates a new height map.
+	// The parameters have been validated against the schema before Generate is called.
+	// Generate should report its progress if progress is not nil.
+	// It should check the context periodically and return ctx.Err() promptly
+	// if the context is canceled or its deadline passes.
+	Generate(ctx context.Context, params Params, rnd *rand.Rand, progress Progress) (*heightmap.Map, error)
+}
+
+// Progress is called by generators to report that done out of total steps are complete.
+type Progress func(done, total int)
+
+// Report calls the progress function, if there is one.
+// Generators should report about a hundred times, not on every step,
+// since the function may be expensive.
+func (p Progress) Report(done, total int) {
+	if p != nil {
+		p(done, total)
+	}
+}
+
+// Interval returns how many steps a generator should take between reports
+// so that it reports about a hundred times.
+func Interval(total int) int {
+	if total < 100 {
+		return 1
+	}
+	return total / 100
+}
+
+var registry = NewRegistry[Generator]("generator")
+
+// Register makes a generator available by name.
+// It panics if the name is empty or if the name is already registered.
+func Register(g Generator) {
+	registry.Register(g)
+}
+
+// Lookup returns the generator registered with the given name.
+func Lookup(name string) (Generator, bool) {
+	return registry.Lookup(name)
+}
+
+// List returns all the registered generators, sorted by name.
+func List() []Generator {
+	return registry.List()
+}
@@ -17,83 +17,192 @@
 package olsson
 
 import (
+	"context"
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/generators"
 	"github.com/mdhender/mapgen/pkg/heightmap"
 	"math"
 	"math/rand"
 )
 
+// XRange and YRange are the width and height of Olsson's original maps.
 const (
-	XRange      = 320
-	YRange      = 160
-	YRangeDiv2  = YRange / 2
-	YRangeDivPI = YRange / math.Pi
+	XRange = 320
+	YRange = 160
 )
 
+func init() {
+	generators.Register(Generator{})
+}
+
+// Generator implements generators.Generator for Olsson's world maps.
+type Generator struct{}
+
+func (Generator) Name() string {
+	return "olsson"
+}
+
+func (Generator) Description() string {
+	return "Generate a map using the original worldmap logic"
+}
+
+func (Generator) Parameters() []generators.Parameter {
+	return []generators.Parameter{
+		{Name: "width", Shorthand: "W", Usage: "Width (in pixels) of map; must be even", Kind: generators.Int, Default: "320", Min: 4, Max: 16 * 1024},
+		{Name: "height", Shorthand: "H", Usage: "Height (in pixels) of map", Kind: generators.Int, Default: "160", Min: 4, Max: 16 * 1024},
+		{Name: "iterations", Shorthand: "i", Usage: "Number of iterations", Kind: generators.Int, Default: "10000", Min: 0, Max: 10_000_000},
+		{Name: "fault", Usage: "Distribution of the height of each fault", Kind: generators.String, Default: "constant", Choices: []string{"constant", "uniform", "exponential"}},
+	}
+}
+
+func (Generator) Generate(ctx context.Context, params generators.Params, rnd *rand.Rand, progress generators.Progress) (*heightmap.Map, error) {
+	width, err := params.Int("width")
+	if err != nil {
+		return nil, err
+	}
+	height, err := params.Int("height")
+	if err != nil {
+		return nil, err
+	}
+	iterations, err := params.Int("iterations")
+	if err != nil {
+		return nil, err
+	}
+	var fault FaultHeight
+	switch params.String("fault") {
+	case "constant":
+		fault = ConstantFault
+	case "uniform":
+		fault = UniformFault
+	case "exponential":
+		fault = ExponentialFault
+	default:
+		return nil, fmt.Errorf("%q: unknown fault distribution", params.String("fault"))
+	}
+	return Generate(ctx, width, height, iterations, fault, rnd, progress)
+}
+
+// FaultHeight returns the amount to raise or lower the terrain along a fault.
+type FaultHeight func(rnd *rand.Rand) float64
+
+// ConstantFault moves every fault by one unit.
+// This is Olsson's original logic.
+func ConstantFault(rnd *rand.Rand) float64 {
+	return 1
+}
+
+// UniformFault moves faults by a random amount between 0 and 2 units.
+func UniformFault(rnd *rand.Rand) float64 {
+	return 2 * rnd.Float64()
+}
+
+// ExponentialFault moves most faults by a small amount and a few by a large amount.
+func ExponentialFault(rnd *rand.Rand) float64 {
+	return rnd.ExpFloat64()
+}
+
+// unset marks points that no fault has crossed.
+var unset = math.Inf(-1)
+
+// WorldMap holds the state for a single map.
+// Nothing is shared between maps, so maps can be generated concurrently.
 type WorldMap struct {
-	Array [][]int
-	rnd   *rand.Rand
+	Array         [][]float64
+	width, height int
+	// sinIterPhi caches sin(x) over two full periods
+	sinIterPhi []float64
+	fault      FaultHeight
+	rnd        *rand.Rand
 }
 
-func Generate(iterations int, rnd *rand.Rand) *heightmap.Map {
+// Generate returns a width x height map.
+// The width must be even since only half the faults are calculated.
+// If fault is nil, ConstantFault is used.
+// Progress is reported as the number of iterations completed.
+// It stops early if the context is canceled.
+func Generate(ctx context.Context, width, height, iterations int, fault FaultHeight, rnd *rand.Rand, progress generators.Progress) (*heightmap.Map, error) {
+	if width < 2 || width%2 != 0 {
+		return nil, fmt.Errorf("width must be even")
+	} else if height < 2 {
+		return nil, fmt.Errorf("height must be at least 2")
+	}
+	if fault == nil {
+		fault = ConstantFault
+	}
 	myWorldMap := &WorldMap{
-		rnd: rnd,
+		width:  width,
+		height: height,
+		fault:  fault,
+		rnd:    rnd,
 	}
-	myWorldMap.Array = make([][]int, YRange, YRange)
-	for y := 0; y < YRange; y++ {
-		myWorldMap.Array[y] = make([]int, XRange, XRange)
+	myWorldMap.Array = make([][]float64, height, height)
+	for y := 0; y < height; y++ {
+		myWorldMap.Array[y] = make([]float64, width, width)
 	}
 
-	SinIterPhi = make([]float64, 2*XRange)
-	for x := 0; x < XRange; x++ {
-		sip := math.Sin(float64(x) * 2 * math.Pi / XRange)
-		SinIterPhi[x] = sip
-		SinIterPhi[x+XRange] = sip
+	myWorldMap.sinIterPhi = make([]float64, 2*width)
+	for x := 0; x < width; x++ {
+		sip := math.Sin(float64(x) * 2 * math.Pi / float64(width))
+		myWorldMap.sinIterPhi[x] = sip
+		myWorldMap.sinIterPhi[x+width] = sip
 	}
 
-	for x, row := 0, 0; x < XRange; x, row = x+1, row+1 {
+	for x := 0; x < width; x++ {
 		myWorldMap.Array[0][x] = 0
-		for y := 1; y < YRange; y++ {
-			myWorldMap.Array[y][x] = math.MinInt
+		for y := 1; y < height; y++ {
+			myWorldMap.Array[y][x] = unset
 		}
 	}
 
+	total, interval := iterations, generators.Interval(iterations)
 	for iterations > 0 {
+		if done := total - iterations; done%interval == 0 {
+			progress.Report(done, total)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
 		raise := myWorldMap.rnd.Intn(2) == 1
 		myWorldMap.iterate(raise)
 		iterations--
 	}
+	progress.Report(total, total)
 
 	/* Copy data (I have only calculated faults for 1/2 the image.
 	 * I can do this due to symmetry... :) */
-	for y := 1; y < YRange; y++ {
-		for x := 0; x < XRange/2; x++ {
-			myWorldMap.Array[YRange-y][x+XRange/2] = myWorldMap.Array[y][x]
+	for y := 1; y < height; y++ {
+		for x := 0; x < width/2; x++ {
+			myWorldMap.Array[height-y][x+width/2] = myWorldMap.Array[y][x]
 		}
 	}
 
 	/* Reconstruct the real WorldMap from the myWorldMap.Array and FaultArray */
-	for x, row := 0, 0; x < XRange; x, row = x+1, row+1 {
+	for x := 0; x < width; x++ {
 		/* We have to start somewhere, and the top ROW was initialized to 0,
 		 * but it might have changed during the iterations... */
 		color := myWorldMap.Array[0][x]
-		for y := 1; y < YRange; y++ {
-			/* We "fill" all positions with values != INT_MIN with z */
+		for y := 1; y < height; y++ {
+			/* We "fill" all positions with values != unset with z */
 			cur := myWorldMap.Array[y][x]
-			if cur != math.MinInt {
+			if cur != unset {
 				color += cur
 			}
 			myWorldMap.Array[y][x] = color
 		}
 	}
 
-	return heightmap.FromArrayOfInt(myWorldMap.Array, heightmap.YXOrientation)
+	hm := heightmap.FromArray(myWorldMap.Array, heightmap.YXOrientation, false)
+	// faults run around the world, so the map wraps east to west
+	hm.WrapX = true
+	return hm, nil
 }
 
-var (
-	SinIterPhi []float64
-)
-
 func (myWorldMap *WorldMap) iterate(raise bool) {
+	width, height := myWorldMap.width, myWorldMap.height
+	yRangeDiv2, yRangeDivPI := height/2, float64(height)/math.Pi
+
 	/* Create a random great circle...
 	 * Start with an equator and rotate it */
 	alpha := (myWorldMap.rnd.Float64() - 0.5) * math.Pi /* Rotate around x-axis */
@@ -101,23 +210,24 @@ func (myWorldMap *WorldMap) iterate(raise bool) {
 
 	tanB := math.Tan(math.Acos(math.Cos(alpha) * math.Cos(beta)))
 
-	xsi := int(XRange/2 - (XRange/math.Pi)*beta)
+	xsi := int(float64(width/2) - (float64(width)/math.Pi)*beta)
 
-	for x, Phi := 0, 0; Phi < XRange/2; x, Phi = x+1, Phi+1 {
-		Theta := YRangeDivPI * math.Atan(SinIterPhi[xsi-Phi+XRange]*tanB)
-		y := int(Theta) + YRangeDiv2
+	dz := myWorldMap.fault(myWorldMap.rnd)
+	for x, Phi := 0, 0; Phi < width/2; x, Phi = x+1, Phi+1 {
+		Theta := yRangeDivPI * math.Atan(myWorldMap.sinIterPhi[xsi-Phi+width]*tanB)
+		y := int(Theta) + yRangeDiv2
 
-		if myWorldMap.Array[y][x] == math.MinInt {
+		if myWorldMap.Array[y][x] == unset {
 			if raise { /* Raise northern hemisphere <=> lower southern */
-				myWorldMap.Array[y][x] = -1
+				myWorldMap.Array[y][x] = -dz
 			} else { /* Raise southern hemisphere */
-				myWorldMap.Array[y][x] = 1
+				myWorldMap.Array[y][x] = dz
 			}
 		} else {
 			if raise { /* Raise northern hemisphere <=> lower southern */
-				myWorldMap.Array[y][x]--
+				myWorldMap.Array[y][x] -= dz
 			} else { /* Raise southern hemisphere */
-				myWorldMap.Array[y][x]++
+				myWorldMap.Array[y][x] += dz
 			}
 		}
 	}
@@ -0,0 +1,156 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package generators
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Kind is the type of value a parameter accepts.
+type Kind int
+
+const (
+	Int Kind = iota
+	Float
+	Bool
+	String
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Int:
+		return "int"
+	case Float:
+		return "float"
+	case Bool:
+		return "bool"
+	case String:
+		return "string"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Parameter describes a single parameter accepted by a generator.
+type Parameter struct {
+	Name      string // name used for flags and form fields
+	Shorthand string // optional single letter flag for the command line
+	Usage     string // one line help text
+	Kind      Kind
+	Default   string // default value, formatted as text
+	// Min and Max are the inclusive bounds for Int and Float parameters.
+	// They are ignored when both are zero.
+	Min, Max float64
+	// Choices, if not empty, lists the only values accepted for a String parameter.
+	Choices []string
+}
+
+// Params holds parameter values, formatted as text, keyed by parameter name.
+// Text is used so that values from flags, forms, and saved files are treated the same.
+type Params map[string]string
+
+// Bool returns the value of a parameter as a boolean.
+func (p Params) Bool(name string) (bool, error) {
+	val, err := strconv.ParseBool(p[name])
+	if err != nil {
+		return false, fmt.Errorf("%q: %w", name, err)
+	}
+	return val, nil
+}
+
+// Float returns the value of a parameter as a float.
+func (p Params) Float(name string) (float64, error) {
+	val, err := strconv.ParseFloat(p[name], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q: %w", name, err)
+	}
+	return val, nil
+}
+
+// Int returns the value of a parameter as an integer.
+func (p Params) Int(name string) (int, error) {
+	val, err := strconv.Atoi(p[name])
+	if err != nil {
+		return 0, fmt.Errorf("%q: %w", name, err)
+	}
+	return val, nil
+}
+
+// String returns the value of a parameter.
+func (p Params) String(name string) string {
+	return p[name]
+}
+
+// Validate checks the values against the schema and returns a new set
+// of parameters with defaults for any missing values.
+// Values that are not in the schema are an error.
+func Validate(schema []Parameter, values Params) (Params, error) {
+	params := Params{}
+	for _, parm := range schema {
+		raw, ok := values[parm.Name]
+		if !ok || raw == "" {
+			raw = parm.Default
+		}
+		switch parm.Kind {
+		case Bool:
+			val, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%q: not a boolean", parm.Name)
+			}
+			raw = strconv.FormatBool(val)
+		case Float:
+			val, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%q: not a number", parm.Name)
+			} else if !parm.inRange(val) {
+				return nil, fmt.Errorf("%q: must be between %g and %g", parm.Name, parm.Min, parm.Max)
+			}
+		case Int:
+			val, err := strconv.Atoi(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%q: not an integer", parm.Name)
+			} else if !parm.inRange(float64(val)) {
+				return nil, fmt.Errorf("%q: must be between %g and %g", parm.Name, parm.Min, parm.Max)
+			}
+			raw = strconv.Itoa(val)
+		case String:
+			if len(parm.Choices) != 0 {
+				found := false
+				for _, choice := range parm.Choices {
+					found = found || raw == choice
+				}
+				if !found {
+					return nil, fmt.Errorf("%q: must be one of %v", parm.Name, parm.Choices)
+				}
+			}
+		}
+		params[parm.Name] = raw
+	}
+	for name := range values {
+		if _, ok := params[name]; !ok {
+			return nil, fmt.Errorf("%q: unknown parameter", name)
+		}
+	}
+	return params, nil
+}
+
+func (p Parameter) inRange(val float64) bool {
+	if p.Min == 0 && p.Max == 0 {
+		return true
+	}
+	return p.Min <= val && val <= p.Max
+}
@@ -0,0 +1,78 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package generators
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Named is implemented by anything that can be registered by name.
+type Named interface {
+	Name() string
+}
+
+// Registry finds things by name.
+// It is shared by the generators and the processors.
+// Registry is safe for concurrent use.
+type Registry[T Named] struct {
+	sync.Mutex
+	kind  string // for panics, such as "generator"
+	items map[string]T
+}
+
+// NewRegistry returns an empty registry for things of the given kind.
+func NewRegistry[T Named](kind string) *Registry[T] {
+	return &Registry[T]{kind: kind, items: make(map[string]T)}
+}
+
+// Register makes an item available by name.
+// It panics if the name is empty or if the name is already registered.
+func (r *Registry[T]) Register(item T) {
+	r.Lock()
+	defer r.Unlock()
+	name := item.Name()
+	if name == "" {
+		panic(fmt.Sprintf("%ss: Register: missing name", r.kind))
+	} else if _, ok := r.items[name]; ok {
+		panic(fmt.Sprintf("%ss: Register: duplicate %s %q", r.kind, r.kind, name))
+	}
+	r.items[name] = item
+}
+
+// Lookup returns the item registered with the given name.
+func (r *Registry[T]) Lookup(name string) (T, bool) {
+	r.Lock()
+	defer r.Unlock()
+	item, ok := r.items[name]
+	return item, ok
+}
+
+// List returns all the registered items, sorted by name.
+func (r *Registry[T]) List() []T {
+	r.Lock()
+	defer r.Unlock()
+	var list []T
+	for _, item := range r.items {
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return list
+}
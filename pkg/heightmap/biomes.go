@@ -0,0 +1,225 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Biome is the kind of land, and what grows on it, in a climate.
+type Biome uint8
+
+const (
+	// BiomeWater is the ocean and lakes.
+	BiomeWater Biome = iota
+	// BiomeIce is land that is frozen all year.
+	BiomeIce
+	// BiomeAlpine is bare rock and meadows above the tree line.
+	BiomeAlpine
+	// BiomeTundra is cold, treeless land.
+	BiomeTundra
+	// BiomeTaiga is the cold forest of conifers.
+	BiomeTaiga
+	// BiomeDesert is land, hot or cold, where little rain falls.
+	BiomeDesert
+	// BiomeGrassland is temperate prairie and steppe.
+	BiomeGrassland
+	// BiomeShrubland is warm, dry woodland and scrub.
+	BiomeShrubland
+	// BiomeTemperateForest is the forest of the middle latitudes.
+	BiomeTemperateForest
+	// BiomeTemperateRainforest is temperate forest where it rains most of the year.
+	BiomeTemperateRainforest
+	// BiomeSavanna is tropical grassland with scattered trees.
+	BiomeSavanna
+	// BiomeTropicalForest is tropical forest with a dry season.
+	BiomeTropicalForest
+	// BiomeTropicalRainforest is tropical forest where it rains all year.
+	BiomeTropicalRainforest
+)
+
+// Biomes lists every biome, from water through the coldest to the warmest.
+var Biomes = []Biome{BiomeWater, BiomeIce, BiomeAlpine, BiomeTundra, BiomeTaiga, BiomeDesert, BiomeGrassland, BiomeShrubland, BiomeTemperateForest, BiomeTemperateRainforest, BiomeSavanna, BiomeTropicalForest, BiomeTropicalRainforest}
+
+var biomeNames = [...]string{
+	BiomeWater:               "water",
+	BiomeIce:                 "ice",
+	BiomeAlpine:              "alpine",
+	BiomeTundra:              "tundra",
+	BiomeTaiga:               "taiga",
+	BiomeDesert:              "desert",
+	BiomeGrassland:           "grassland",
+	BiomeShrubland:           "shrubland",
+	BiomeTemperateForest:     "temperate-forest",
+	BiomeTemperateRainforest: "temperate-rainforest",
+	BiomeSavanna:             "savanna",
+	BiomeTropicalForest:      "tropical-forest",
+	BiomeTropicalRainforest:  "tropical-rainforest",
+}
+
+// BiomeColors is the color of each biome.
+var BiomeColors = [...]color.RGBA{
+	BiomeWater:               {R: 54, G: 96, B: 166, A: 255},
+	BiomeIce:                 {R: 240, G: 246, B: 250, A: 255},
+	BiomeAlpine:              {R: 160, G: 150, B: 140, A: 255},
+	BiomeTundra:              {R: 178, G: 190, B: 168, A: 255},
+	BiomeTaiga:               {R: 64, G: 110, B: 88, A: 255},
+	BiomeDesert:              {R: 232, G: 212, B: 152, A: 255},
+	BiomeGrassland:           {R: 192, G: 210, B: 120, A: 255},
+	BiomeShrubland:           {R: 176, G: 170, B: 90, A: 255},
+	BiomeTemperateForest:     {R: 92, G: 150, B: 72, A: 255},
+	BiomeTemperateRainforest: {R: 42, G: 112, B: 72, A: 255},
+	BiomeSavanna:             {R: 212, G: 188, B: 98, A: 255},
+	BiomeTropicalForest:      {R: 112, G: 160, B: 50, A: 255},
+	BiomeTropicalRainforest:  {R: 22, G: 108, B: 40, A: 255},
+}
+
+// String returns the name of the biome.
+func (b Biome) String() string {
+	if int(b) < len(biomeNames) {
+		return biomeNames[b]
+	}
+	return fmt.Sprintf("biome(%d)", int(b))
+}
+
+// Color returns the color of the biome.
+func (b Biome) Color() color.RGBA {
+	if int(b) < len(BiomeColors) {
+		return BiomeColors[b]
+	}
+	return noValue
+}
+
+// alpineHeight is the height, in kilometers, above which cold land is alpine.
+const alpineHeight = 3
+
+// classify returns the biome for the climate of a pixel of land,
+// following Whittaker's diagram of temperature (in degrees Celsius)
+// and precipitation (in millimeters a year).
+// Land that is high and cold is alpine, however wet or dry it is.
+func classify(temperature, precipitation, height float64) Biome {
+	if temperature < -10 {
+		return BiomeIce
+	} else if height >= alpineHeight && temperature < 5 {
+		return BiomeAlpine
+	} else if temperature < -3 {
+		return BiomeTundra
+	} else if temperature < 5 {
+		if precipitation < 250 {
+			return BiomeTundra
+		}
+		return BiomeTaiga
+	} else if temperature < 20 {
+		if precipitation < 250 {
+			return BiomeDesert
+		} else if precipitation < 500 {
+			return BiomeGrassland
+		} else if precipitation < 1000 && temperature >= 10 {
+			return BiomeShrubland
+		} else if precipitation < 2200 {
+			return BiomeTemperateForest
+		}
+		return BiomeTemperateRainforest
+	} else if precipitation < 400 {
+		return BiomeDesert
+	} else if precipitation < 1200 {
+		return BiomeSavanna
+	} else if precipitation < 2500 {
+		return BiomeTropicalForest
+	}
+	return BiomeTropicalRainforest
+}
+
+// BiomeMap holds the biome of every pixel of a map, indexed as [x][y] like Map.Data.
+type BiomeMap [][]Biome
+
+// Biomes returns the biome of every pixel.
+func (c *Climate) Biomes() BiomeMap {
+	bm := make(BiomeMap, len(c.Temperature))
+	for x := range bm {
+		bm[x] = make([]Biome, len(c.Temperature[x]))
+		for y := range bm[x] {
+			if c.Water[x][y] {
+				bm[x][y] = BiomeWater
+			} else {
+				bm[x][y] = classify(c.Temperature[x][y], c.Precipitation[x][y], c.Height[x][y])
+			}
+		}
+	}
+	return bm
+}
+
+// Image draws every pixel in the color of its biome.
+func (bm BiomeMap) Image() *image.RGBA {
+	maxx, maxy := len(bm), len(bm[0])
+	img := image.NewRGBA(image.Rect(0, 0, maxx, maxy))
+	for x := 0; x < maxx; x++ {
+		for y := 0; y < maxy; y++ {
+			img.Set(x, y, bm[x][y].Color())
+		}
+	}
+	return img
+}
+
+// BiomeArea is how much of a map a biome covers.
+type BiomeArea struct {
+	Biome Biome
+	// Pixels is the number of pixels in the biome.
+	Pixels int
+	// Area is the ground covered by the pixels, in square kilometers.
+	Area float64
+	// Percent is the percentage of the area of the map.
+	Percent float64
+	// PercentOfLand is the percentage of the area of the land.
+	// It is 0 for water.
+	PercentOfLand float64
+}
+
+// BiomeAreas returns the area of every biome on the map, in the order of Biomes.
+// Biomes that aren't on the map are left out.
+func (c *Climate) BiomeAreas() []BiomeArea {
+	areas := make([]BiomeArea, len(Biomes))
+	var total, land float64
+	for x, col := range c.Biomes() {
+		for y, b := range col {
+			areas[b].Pixels++
+			areas[b].Area += c.Area[y]
+			total += c.Area[y]
+			if !c.Water[x][y] {
+				land += c.Area[y]
+			}
+		}
+	}
+	var list []BiomeArea
+	for _, b := range Biomes {
+		a := areas[b]
+		if a.Pixels == 0 {
+			continue
+		}
+		a.Biome = b
+		if total > 0 {
+			a.Percent = 100 * a.Area / total
+		}
+		if land > 0 && b != BiomeWater {
+			a.PercentOfLand = 100 * a.Area / land
+		}
+		list = append(list, a)
+	}
+	return list
+}
@@ -0,0 +1,298 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+)
+
+// The climate model treats the map as a planet in a cylindrical projection:
+// the rows are lines of latitude, from North at the top to South at the bottom,
+// and the map is Circumference wide. It is meant to look plausible, not to be
+// accurate; there are no seasons, currents, or storms.
+
+// ClimateOptions are the settings for the climate model.
+type ClimateOptions struct {
+	// SeaLevel is the elevation below which pixels are water.
+	SeaLevel float64
+	// North and South are the latitudes of the top and bottom of the map, in degrees.
+	North, South float64
+	// Circumference is the distance across the map, in kilometers.
+	Circumference float64
+	// Peak is the height of the highest point on the map above sea level, in kilometers.
+	Peak float64
+	// Equator and Pole are the average temperatures at sea level, in degrees Celsius.
+	Equator, Pole float64
+	// LapseRate is how much colder it gets going up, in degrees per kilometer.
+	LapseRate float64
+	// Moderation is how far the ocean pulls the temperature toward the
+	// average of Equator and Pole, from 0 (not at all) to 1 (all the way).
+	Moderation float64
+	// Reach is how far inland the ocean moderates the temperature, in kilometers.
+	// The effect falls off exponentially with the distance from the water.
+	Reach float64
+	// Rainfall is the precipitation on flat land by the ocean at the equator,
+	// in millimeters per year.
+	Rainfall float64
+	// Drizzle is the fraction of its moisture that air loses crossing
+	// a thousand kilometers of flat land.
+	Drizzle float64
+	// Orographic is the fraction of its moisture that air loses for each
+	// kilometer that it is pushed up a mountain.
+	Orographic float64
+	// Evaporation is the fraction of the missing moisture that air picks up
+	// crossing a thousand kilometers of water.
+	Evaporation float64
+}
+
+// DefaultClimate is a climate like Earth's, for a map that runs from pole to pole.
+var DefaultClimate = ClimateOptions{
+	North:         90,
+	South:         -90,
+	Circumference: 40_000,
+	Peak:          6,
+	Equator:       30,
+	Pole:          -25,
+	LapseRate:     6.5,
+	Moderation:    0.25,
+	Reach:         500,
+	Rainfall:      2_500,
+	Drizzle:       0.3,
+	Orographic:    0.5,
+	Evaporation:   1,
+}
+
+// Validate returns an error if the options can't be used.
+func (o ClimateOptions) Validate() error {
+	if o.North < -90 || o.North > 90 || o.South < -90 || o.South > 90 {
+		return fmt.Errorf("north and south: must be between -90 and 90")
+	} else if o.North == o.South {
+		return fmt.Errorf("north and south: must not be the same")
+	} else if o.Circumference <= 0 {
+		return fmt.Errorf("circumference: must be greater than 0")
+	} else if o.Moderation < 0 || o.Moderation > 1 {
+		return fmt.Errorf("moderation: must be between 0 and 1")
+	} else if o.Reach <= 0 {
+		return fmt.Errorf("reach: must be greater than 0")
+	} else if o.Drizzle <= 0 {
+		return fmt.Errorf("drizzle: must be greater than 0")
+	}
+	for _, f := range []struct {
+		name  string
+		value float64
+	}{
+		{"peak", o.Peak},
+		{"lapse rate", o.LapseRate},
+		{"rainfall", o.Rainfall},
+		{"orographic", o.Orographic},
+		{"evaporation", o.Evaporation},
+	} {
+		if f.value < 0 {
+			return fmt.Errorf("%s: must not be negative", f.name)
+		}
+	}
+	return nil
+}
+
+// Climate is the average weather at every pixel of a map.
+type Climate struct {
+	// Latitude is the latitude of each row of the map, in degrees.
+	Latitude []float64
+	// Area is the area of a pixel in each row of the map, in square kilometers.
+	// Pixels cover less ground nearer the poles.
+	Area []float64
+	// Water is set for the pixels below sea level.
+	Water [][]bool
+	// Height is the height of every pixel above sea level, in kilometers.
+	// It is 0 for water.
+	Height Grid
+	// Temperature is the average temperature, in degrees Celsius.
+	// For water, it is the temperature of the surface.
+	Temperature Grid
+	// Precipitation is the rain and snow that falls in a year, in millimeters.
+	Precipitation Grid
+}
+
+// Climate models the temperature and precipitation of the map.
+//
+// The temperature falls from the equator to the poles and falls with height
+// at the lapse rate. The ocean is milder than the land, and it moderates
+// the land near it.
+//
+// Moisture is carried by the prevailing winds: the trade winds blow from
+// the east in the tropics, the westerlies blow from the west at middle
+// latitudes, and the polar easterlies blow from the east near the poles.
+// Air picks up moisture over water and loses it over land, most of all
+// where it is pushed up mountains, which leaves a rain shadow behind them.
+// The wettest latitudes are the equator and the edges of the polar cells,
+// and the driest are the horse latitudes and the poles.
+func (hm *Map) Climate(opts ClimateOptions) (*Climate, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	c := &Climate{
+		Latitude:      make([]float64, maxy),
+		Area:          make([]float64, maxy),
+		Water:         make([][]bool, maxx),
+		Height:        newGrid(maxx, maxy),
+		Temperature:   newGrid(maxx, maxy),
+		Precipitation: newGrid(maxx, maxy),
+	}
+	// the size of a pixel, in kilometers. pixels are the same height everywhere,
+	// but the lines of latitude get shorter toward the poles, so pixels get narrower.
+	// they are never narrower than a hundredth of their width at the equator,
+	// which keeps the rows at the poles from shrinking to nothing.
+	ky := opts.Circumference * math.Abs(opts.North-opts.South) / 360 / float64(maxy)
+	kx := make([]float64, maxy)
+	for y := range c.Latitude {
+		c.Latitude[y] = opts.North + (opts.South-opts.North)*(float64(y)+0.5)/float64(maxy)
+		c.Area[y] = opts.Circumference / float64(maxx) * ky * math.Cos(c.Latitude[y]*math.Pi/180)
+		kx[y] = opts.Circumference / float64(maxx) * math.Max(0.01, math.Cos(c.Latitude[y]*math.Pi/180))
+	}
+	for x := 0; x < maxx; x++ {
+		c.Water[x] = make([]bool, maxy)
+		for y := 0; y < maxy; y++ {
+			if z := hm.Data[x][y]; z < opts.SeaLevel {
+				c.Water[x][y] = true
+			} else if hm.MaxZ > opts.SeaLevel {
+				c.Height[x][y] = (z - opts.SeaLevel) / (hm.MaxZ - opts.SeaLevel) * opts.Peak
+			}
+		}
+	}
+
+	// temperature
+	dist := hm.waterDistance(opts.SeaLevel, kx, ky)
+	mild := (opts.Equator + opts.Pole) / 2
+	for y, lat := range c.Latitude {
+		land := opts.Pole + (opts.Equator-opts.Pole)*math.Cos(lat*math.Pi/180)
+		sea := land + opts.Moderation*(mild-land)
+		for x := 0; x < maxx; x++ {
+			if c.Water[x][y] {
+				c.Temperature[x][y] = sea
+				continue
+			}
+			w := math.Exp(-dist[x][y] / opts.Reach)
+			c.Temperature[x][y] = land + w*(sea-land) - opts.LapseRate*c.Height[x][y]
+		}
+	}
+
+	// precipitation, one row at a time in the direction of the wind
+	for y, lat := range c.Latitude {
+		rain := opts.Rainfall * rainBand(lat)
+		drizzle := 1 - math.Exp(-opts.Drizzle*kx[y]/1000)
+		evaporation := 1 - math.Exp(-opts.Evaporation*kx[y]/1000)
+		start, step := maxx-1, -1
+		if a := math.Abs(lat); 30 <= a && a < 60 {
+			start, step = 0, 1
+		}
+		// air blowing in over an edge that doesn't wrap is as moist as air from the ocean.
+		// on a map that wraps, the air goes around twice so that it arrives
+		// at the start of the row with the moisture it picked up on the way.
+		laps := 1
+		if hm.WrapX {
+			laps = 2
+		}
+		// the air rises over the average height around each pixel,
+		// so that it isn't lifted by every bump in rough terrain
+		profile := make([]float64, maxx)
+		for x := range profile {
+			for dx := -liftRadius; dx <= liftRadius; dx++ {
+				profile[x] += c.Height[index(x+dx, maxx, hm.WrapX)][y]
+			}
+			profile[x] /= 2*liftRadius + 1
+		}
+		moisture, prev := 1.0, profile[start]
+		for n := 0; n < laps*maxx; n++ {
+			x := index(start+n*step, maxx, true)
+			lift := math.Max(0, profile[x]-prev)
+			prev = profile[x]
+			if c.Water[x][y] {
+				c.Precipitation[x][y] = rain * moisture
+				moisture += (1 - moisture) * evaporation
+				continue
+			}
+			lost := moisture * (1 - math.Exp(-opts.Drizzle*kx[y]/1000-opts.Orographic*lift))
+			c.Precipitation[x][y] = rain * lost / drizzle
+			moisture -= lost
+			// plants and wet ground give back some of the rain
+			moisture += (1 - moisture) * evaporation * landEvaporation
+		}
+	}
+	return c, nil
+}
+
+// liftRadius is the number of pixels on either side of a pixel that are
+// averaged to find how high the air is pushed.
+const liftRadius = 3
+
+// landEvaporation is how much moisture air picks up over land,
+// as a fraction of what it picks up over water.
+const landEvaporation = 0.25
+
+// rainBand returns how wet the latitude is, from 0 to 1, with the most rain
+// at the equator and at 60 degrees and the least at 30 degrees and the poles.
+func rainBand(lat float64) float64 {
+	a := math.Abs(lat)
+	band := (1 + math.Cos(6*a*math.Pi/180)) / 2
+	return 0.15 + 0.85*band*(1-a/180)
+}
+
+// waterDistance returns the distance from every pixel to the nearest water,
+// in kilometers. Pixels are infinitely far if there is no water.
+// kx is the width of the pixels in each row and ky is their height.
+// Steps between rows use the average width of the two rows.
+func (hm *Map) waterDistance(seaLevel float64, kx []float64, ky float64) Grid {
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	dist := newGrid(maxx, maxy)
+	var q cellQueue
+	for x := 0; x < maxx; x++ {
+		for y := 0; y < maxy; y++ {
+			if hm.Data[x][y] < seaLevel {
+				q = append(q, cell{x: x, y: y})
+			} else {
+				dist[x][y] = math.Inf(1)
+			}
+		}
+	}
+	heap.Init(&q)
+	for q.Len() != 0 {
+		c := heap.Pop(&q).(cell)
+		if c.z > dist[c.x][c.y] {
+			continue
+		}
+		for _, nb := range d8 {
+			nx, ny := c.x+nb.dx, c.y+nb.dy
+			if hm.WrapX {
+				nx = index(nx, maxx, true)
+			}
+			if hm.WrapY {
+				ny = index(ny, maxy, true)
+			}
+			if nx < 0 || nx >= maxx || ny < 0 || ny >= maxy {
+				continue
+			}
+			if z := c.z + math.Hypot(float64(nb.dx)*(kx[c.y]+kx[ny])/2, float64(nb.dy)*ky); z < dist[nx][ny] {
+				dist[nx][ny] = z
+				heap.Push(&q, cell{z: z, x: nx, y: ny})
+			}
+		}
+	}
+	return dist
+}
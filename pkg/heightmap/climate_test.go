@@ -0,0 +1,101 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWaterDistance(t *testing.T) {
+	// a 5x3 map of land with water in the first column
+	hm := &Map{MaxZ: 1, Data: newGrid(5, 3)}
+	for x := 1; x < 5; x++ {
+		for y := 0; y < 3; y++ {
+			hm.Data[x][y] = 1
+		}
+	}
+	for _, tc := range []struct {
+		name  string
+		wrapX bool
+		kx    []float64
+		want  [5]float64 // the distances along the middle row
+	}{
+		{"square", false, []float64{1, 1, 1}, [5]float64{0, 1, 2, 3, 4}},
+		{"narrow", false, []float64{0.5, 0.5, 0.5}, [5]float64{0, 0.5, 1, 1.5, 2}},
+		// the middle row is the narrowest, so the shortest path stays on it
+		{"narrow in the middle", false, []float64{2, 0.25, 2}, [5]float64{0, 0.25, 0.5, 0.75, 1}},
+		{"wraps", true, []float64{0.5, 0.5, 0.5}, [5]float64{0, 0.5, 1, 1, 0.5}},
+	} {
+		hm.WrapX = tc.wrapX
+		dist := hm.waterDistance(0.5, tc.kx, 1)
+		for x, want := range tc.want {
+			if got := dist[x][1]; math.Abs(got-want) > 1e-9 {
+				t.Errorf("%s: %d: got %g, want %g", tc.name, x, got, want)
+			}
+		}
+	}
+
+	// a diagonal step between rows uses the average width of the two rows
+	hm = &Map{MaxZ: 1, Data: [][]float64{{1, 0}, {1, 1}}}
+	if got, want := hm.waterDistance(0.5, []float64{1, 1.2}, 1)[1][0], math.Hypot(1.1, 1); math.Abs(got-want) > 1e-9 {
+		t.Errorf("diagonal: got %g, want %g", got, want)
+	}
+}
+
+func TestClimateNarrowRows(t *testing.T) {
+	// flat land with water in the first column
+	hm := &Map{MaxZ: 1, Data: newGrid(64, 32)}
+	for x := 1; x < 64; x++ {
+		for y := 0; y < 32; y++ {
+			hm.Data[x][y] = 0.5
+		}
+	}
+	// without evaporation or mountains, the air loses moisture at the same rate
+	// for every kilometer it crosses, and the rows are narrower nearer the poles
+	opts := DefaultClimate
+	opts.SeaLevel, opts.Evaporation, opts.Orographic = 0.25, 0, 0
+	c, err := hm.Climate(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, y := range []int{2, 5, 10, 21, 29} {
+		lat := c.Latitude[y]
+		if a := math.Abs(lat); a < 30 || a >= 60 {
+			// the wind blows from the east, off the edge of the map
+			continue
+		}
+		width := opts.Circumference / 64 * math.Cos(lat*math.Pi/180)
+		want := math.Exp(-opts.Drizzle * width * 24 / 1000)
+		if got := c.Precipitation[32][y] / c.Precipitation[8][y]; math.Abs(got-want) > 1e-9 {
+			t.Errorf("%g degrees: got %g of the rain after 24 pixels, want %g", lat, got, want)
+		}
+	}
+
+	// the ocean moderates the temperature less far inland where the rows are wider
+	moderated := func(y int) float64 {
+		lat := c.Latitude[y]
+		land := opts.Pole + (opts.Equator-opts.Pole)*math.Cos(lat*math.Pi/180)
+		sea := land + opts.Moderation*((opts.Equator+opts.Pole)/2-land)
+		return (c.Temperature[4][y] + opts.LapseRate*c.Height[4][y] - land) / (sea - land)
+	}
+	for y := 1; y < 16; y++ {
+		if moderated(y) >= moderated(y-1) {
+			t.Errorf("%g degrees: got %g, want less than %g at %g degrees", c.Latitude[y], moderated(y), moderated(y-1), c.Latitude[y-1])
+		}
+	}
+}
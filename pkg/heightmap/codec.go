@@ -0,0 +1,355 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+// The binary file format is
+//
+//	magic       [4]byte  "MGHM"
+//	version     uint16   currently 1
+//	flags       uint16   see the flag constants; compression and wrapping
+//	width       uint32
+//	height      uint32
+//	orientation uint8    XYOrientation (column major) or YXOrientation (row major)
+//	data type   uint8    see DataType
+//	reserved    uint16
+//	min z       float64
+//	max z       float64
+//	meta length uint32
+//	metadata    [meta length]byte, JSON encoded Metadata
+//	elevations  width*height values, gzip compressed when flagCompressed is set
+//
+// All values are little endian.
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Extension is the file extension for maps in the binary format.
+const Extension = ".hmap"
+
+var magic = [4]byte{'M', 'G', 'H', 'M'}
+
+const formatVersion = 1
+
+// The sizes in the header are checked against these limits before anything
+// is allocated, so that a damaged or hostile file can't exhaust memory.
+const (
+	// maxDimension is the largest width or height of a map.
+	maxDimension = 64 * 1024
+	// maxPixels is the largest number of pixels in a map.
+	maxPixels = 64 * 1024 * 1024
+	// maxMetaLength is the largest size of the metadata, in bytes.
+	maxMetaLength = 4 * 1024 * 1024
+)
+
+const (
+	flagCompressed = 1 << iota
+	flagWrapX
+	flagWrapY
+)
+
+// DataType is the type used to store elevations in the binary format.
+type DataType uint8
+
+const (
+	Float64 DataType = iota + 1
+	Float32
+	// Uint16 quantizes elevations between MinZ and MaxZ into 65,536 steps.
+	Uint16
+)
+
+func (dt DataType) size() int {
+	switch dt {
+	case Float64:
+		return 8
+	case Float32:
+		return 4
+	case Uint16:
+		return 2
+	}
+	return 0
+}
+
+// EncodeOptions controls how Encode writes a map.
+type EncodeOptions struct {
+	DataType    DataType // defaults to Float32
+	Orientation Orientation
+	Compress    bool
+}
+
+type header struct {
+	Magic       [4]byte
+	Version     uint16
+	Flags       uint16
+	Width       uint32
+	Height      uint32
+	Orientation uint8
+	DataType    uint8
+	Reserved    uint16
+	MinZ, MaxZ  float64
+	MetaLength  uint32
+}
+
+// Encode writes the map to w in the binary format.
+func (hm *Map) Encode(w io.Writer, opts EncodeOptions) error {
+	if opts.DataType == 0 {
+		opts.DataType = Float32
+	} else if opts.DataType.size() == 0 {
+		return fmt.Errorf("encode: unknown data type %d", opts.DataType)
+	}
+	if opts.Orientation != XYOrientation && opts.Orientation != YXOrientation {
+		return fmt.Errorf("encode: unknown orientation %d", opts.Orientation)
+	}
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	if maxx > maxDimension || maxy > maxDimension || maxx*maxy > maxPixels {
+		return fmt.Errorf("encode: %dx%d: map is too large", maxx, maxy)
+	}
+
+	var meta []byte
+	if hm.Metadata != nil {
+		var err error
+		if meta, err = json.Marshal(hm.Metadata); err != nil {
+			return fmt.Errorf("encode: %w", err)
+		} else if len(meta) > maxMetaLength {
+			return fmt.Errorf("encode: metadata: %d bytes is too large", len(meta))
+		}
+	}
+
+	h := header{
+		Magic:       magic,
+		Version:     formatVersion,
+		Width:       uint32(maxx),
+		Height:      uint32(maxy),
+		Orientation: uint8(opts.Orientation),
+		DataType:    uint8(opts.DataType),
+		MinZ:        hm.MinZ,
+		MaxZ:        hm.MaxZ,
+		MetaLength:  uint32(len(meta)),
+	}
+	if opts.Compress {
+		h.Flags |= flagCompressed
+	}
+	if hm.WrapX {
+		h.Flags |= flagWrapX
+	}
+	if hm.WrapY {
+		h.Flags |= flagWrapY
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.LittleEndian, h); err != nil {
+		return fmt.Errorf("encode: %w", err)
+	} else if _, err = bw.Write(meta); err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	var dw io.Writer = bw
+	var zw *gzip.Writer
+	if opts.Compress {
+		zw = gzip.NewWriter(bw)
+		dw = zw
+	}
+
+	// convert one column (or row) at a time to keep memory use down
+	var n int
+	if opts.Orientation == XYOrientation {
+		n = maxy
+	} else {
+		n = maxx
+	}
+	buf := make([]byte, n*opts.DataType.size())
+	scale := hm.MaxZ - hm.MinZ
+	if scale != 0 {
+		scale = math.MaxUint16 / scale
+	}
+	for i := 0; i < maxx*maxy/n; i++ {
+		for j := 0; j < n; j++ {
+			var e float64
+			if opts.Orientation == XYOrientation {
+				e = hm.Data[i][j]
+			} else {
+				e = hm.Data[j][i]
+			}
+			switch opts.DataType {
+			case Float64:
+				binary.LittleEndian.PutUint64(buf[j*8:], math.Float64bits(e))
+			case Float32:
+				binary.LittleEndian.PutUint32(buf[j*4:], math.Float32bits(float32(e)))
+			case Uint16:
+				binary.LittleEndian.PutUint16(buf[j*2:], uint16(math.Round((e-hm.MinZ)*scale)))
+			}
+		}
+		if _, err := dw.Write(buf); err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+	}
+
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+	}
+	return bw.Flush()
+}
+
+// Decode reads a map in either the binary format or the legacy JSON format.
+func Decode(r io.Reader) (*Map, error) {
+	br := bufio.NewReader(r)
+	if !isBinary(br) {
+		var hm *Map
+		if err := json.NewDecoder(br).Decode(&hm); err != nil {
+			return nil, fmt.Errorf("decode: %w", err)
+		} else if hm == nil || len(hm.Data) == 0 || len(hm.Data[0]) == 0 {
+			return nil, fmt.Errorf("decode: empty map")
+		}
+		maxx, maxy := len(hm.Data), len(hm.Data[0])
+		if maxx > maxDimension || maxy > maxDimension || maxx*maxy > maxPixels {
+			return nil, fmt.Errorf("decode: %dx%d: map is too large", maxx, maxy)
+		}
+		for x, col := range hm.Data {
+			if len(col) != maxy {
+				return nil, fmt.Errorf("decode: column %d: %d elevations, want %d", x, len(col), maxy)
+			}
+		}
+		return hm, nil
+	}
+
+	h, meta, err := decodeHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	dt := DataType(h.DataType)
+	if dt.size() == 0 {
+		return nil, fmt.Errorf("decode: unknown data type %d", h.DataType)
+	} else if o := Orientation(h.Orientation); o != XYOrientation && o != YXOrientation {
+		return nil, fmt.Errorf("decode: unknown orientation %d", h.Orientation)
+	} else if h.Width == 0 || h.Height == 0 {
+		return nil, fmt.Errorf("decode: empty map")
+	} else if h.Width > maxDimension || h.Height > maxDimension || uint64(h.Width)*uint64(h.Height) > maxPixels {
+		return nil, fmt.Errorf("decode: %dx%d: map is too large", h.Width, h.Height)
+	}
+
+	var dr io.Reader = br
+	var zr *gzip.Reader
+	if h.Flags&flagCompressed != 0 {
+		if zr, err = gzip.NewReader(br); err != nil {
+			return nil, fmt.Errorf("decode: %w", err)
+		}
+		defer zr.Close()
+		dr = zr
+	}
+
+	maxx, maxy := int(h.Width), int(h.Height)
+	hm := &Map{MinZ: h.MinZ, MaxZ: h.MaxZ, Metadata: meta, Data: make([][]float64, maxx, maxx)}
+	hm.WrapX, hm.WrapY = h.Flags&flagWrapX != 0, h.Flags&flagWrapY != 0
+	data := make([]float64, maxx*maxy)
+	for x := 0; x < maxx; x++ {
+		hm.Data[x] = data[x*maxy : (x+1)*maxy]
+	}
+
+	var n int
+	if Orientation(h.Orientation) == XYOrientation {
+		n = maxy
+	} else {
+		n = maxx
+	}
+	buf := make([]byte, n*dt.size())
+	scale := (h.MaxZ - h.MinZ) / math.MaxUint16
+	for i := 0; i < maxx*maxy/n; i++ {
+		if _, err := io.ReadFull(dr, buf); err != nil {
+			return nil, fmt.Errorf("decode: %w", err)
+		}
+		for j := 0; j < n; j++ {
+			var e float64
+			switch dt {
+			case Float64:
+				e = math.Float64frombits(binary.LittleEndian.Uint64(buf[j*8:]))
+			case Float32:
+				e = float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[j*4:])))
+			case Uint16:
+				e = h.MinZ + float64(binary.LittleEndian.Uint16(buf[j*2:]))*scale
+			}
+			if Orientation(h.Orientation) == XYOrientation {
+				hm.Data[i][j] = e
+			} else {
+				hm.Data[j][i] = e
+			}
+		}
+	}
+	// gzip only checks the data against its checksum at the end of the stream
+	if zr != nil {
+		if _, err := io.Copy(io.Discard, zr); err != nil {
+			return nil, fmt.Errorf("decode: %w", err)
+		}
+	}
+
+	return hm, nil
+}
+
+// DecodeMetadata reads only the metadata from a map.
+// It is much faster than Decode for maps in the binary format.
+// It returns nil if the map does not have any metadata.
+func DecodeMetadata(r io.Reader) (*Metadata, error) {
+	br := bufio.NewReader(r)
+	if !isBinary(br) {
+		hm, err := Decode(br)
+		if err != nil {
+			return nil, err
+		}
+		return hm.Metadata, nil
+	}
+	_, meta, err := decodeHeader(br)
+	return meta, err
+}
+
+// isBinary returns true if the reader starts with the magic number.
+func isBinary(br *bufio.Reader) bool {
+	pfx, err := br.Peek(len(magic))
+	return err == nil && bytes.Equal(pfx, magic[:])
+}
+
+func decodeHeader(r io.Reader) (header, *Metadata, error) {
+	var h header
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return h, nil, fmt.Errorf("decode: %w", err)
+	} else if h.Magic != magic {
+		return h, nil, fmt.Errorf("decode: not a map file")
+	} else if h.Version != formatVersion {
+		return h, nil, fmt.Errorf("decode: unsupported version %d", h.Version)
+	} else if h.MetaLength > maxMetaLength {
+		return h, nil, fmt.Errorf("decode: metadata: %d bytes is too large", h.MetaLength)
+	}
+	if h.MetaLength == 0 {
+		return h, nil, nil
+	}
+	raw := make([]byte, h.MetaLength)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return h, nil, fmt.Errorf("decode: %w", err)
+	}
+	var meta Metadata
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return h, nil, fmt.Errorf("decode: metadata: %w", err)
+	}
+	return h, &meta, nil
+}
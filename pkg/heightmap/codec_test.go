@@ -0,0 +1,213 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// codecMap returns a 4x3 map with fractional elevations between -2 and 3.
+func codecMap() *Map {
+	hm := &Map{MinZ: -2, MaxZ: 3, Data: make([][]float64, 4)}
+	for x := range hm.Data {
+		hm.Data[x] = make([]float64, 3)
+		for y := range hm.Data[x] {
+			hm.Data[x][y] = -2 + float64(x*3+y)*5/11
+		}
+	}
+	return hm
+}
+
+func TestEncodeDecode(t *testing.T) {
+	meta := &Metadata{
+		Generator:  "flat",
+		Params:     map[string]string{"width": "4", "height": "3"},
+		Seed:       42,
+		Version:    "0.0.1",
+		Created:    time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC),
+		Elapsed:    1500 * time.Millisecond,
+		Processing: []string{"erode-thermal --seed=0"},
+	}
+	for _, tc := range []struct {
+		name         string
+		opts         EncodeOptions
+		tolerance    float64
+		wrapX, wrapY bool
+		meta         *Metadata
+	}{
+		{name: "float32", opts: EncodeOptions{DataType: Float32}, tolerance: 1e-6},
+		{name: "float64", opts: EncodeOptions{DataType: Float64}},
+		{name: "uint16", opts: EncodeOptions{DataType: Uint16}, tolerance: 5.0 / math.MaxUint16 / 2},
+		{name: "default data type", tolerance: 1e-6},
+		{name: "row major", opts: EncodeOptions{DataType: Float64, Orientation: YXOrientation}},
+		{name: "compressed", opts: EncodeOptions{DataType: Float64, Compress: true}},
+		{name: "compressed uint16", opts: EncodeOptions{DataType: Uint16, Orientation: YXOrientation, Compress: true}, tolerance: 5.0 / math.MaxUint16 / 2},
+		{name: "wraps left to right", opts: EncodeOptions{DataType: Float64}, wrapX: true},
+		{name: "wraps top to bottom", opts: EncodeOptions{DataType: Float64}, wrapY: true},
+		{name: "wraps both ways", opts: EncodeOptions{DataType: Float64, Compress: true}, wrapX: true, wrapY: true},
+		{name: "metadata", opts: EncodeOptions{DataType: Float64}, meta: meta},
+	} {
+		hm := codecMap()
+		hm.WrapX, hm.WrapY, hm.Metadata = tc.wrapX, tc.wrapY, tc.meta
+		var bb bytes.Buffer
+		if err := hm.Encode(&bb, tc.opts); err != nil {
+			t.Errorf("%s: encode: unexpected error %v", tc.name, err)
+			continue
+		}
+		got, err := Decode(bytes.NewReader(bb.Bytes()))
+		if err != nil {
+			t.Errorf("%s: decode: unexpected error %v", tc.name, err)
+			continue
+		}
+		if got.MinZ != hm.MinZ || got.MaxZ != hm.MaxZ {
+			t.Errorf("%s: got range %g %g, want %g %g", tc.name, got.MinZ, got.MaxZ, hm.MinZ, hm.MaxZ)
+		}
+		if len(got.Data) != len(hm.Data) || len(got.Data[0]) != len(hm.Data[0]) {
+			t.Errorf("%s: got %dx%d, want %dx%d", tc.name, len(got.Data), len(got.Data[0]), len(hm.Data), len(hm.Data[0]))
+			continue
+		}
+		for x := range hm.Data {
+			for y, want := range hm.Data[x] {
+				if e := got.Data[x][y]; math.Abs(e-want) > tc.tolerance {
+					t.Errorf("%s: %d %d: got %g, want %g", tc.name, x, y, e, want)
+				}
+			}
+		}
+		if got.WrapX != tc.wrapX || got.WrapY != tc.wrapY {
+			t.Errorf("%s: got wrap %v %v, want %v %v", tc.name, got.WrapX, got.WrapY, tc.wrapX, tc.wrapY)
+		}
+		if !reflect.DeepEqual(got.Metadata, tc.meta) {
+			t.Errorf("%s: got metadata %+v, want %+v", tc.name, got.Metadata, tc.meta)
+		}
+		if tc.meta != nil {
+			dm, err := DecodeMetadata(bytes.NewReader(bb.Bytes()))
+			if err != nil {
+				t.Errorf("%s: decode metadata: unexpected error %v", tc.name, err)
+			} else if !reflect.DeepEqual(dm, tc.meta) {
+				t.Errorf("%s: decode metadata: got %+v, want %+v", tc.name, dm, tc.meta)
+			}
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts EncodeOptions
+	}{
+		{"unknown data type", EncodeOptions{DataType: 9}},
+		{"unknown orientation", EncodeOptions{Orientation: 7}},
+	} {
+		if err := codecMap().Encode(&bytes.Buffer{}, tc.opts); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestDecodeLegacy(t *testing.T) {
+	hm := codecMap()
+	raw, err := json.Marshal(hm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if !reflect.DeepEqual(got.Data, hm.Data) || got.MinZ != hm.MinZ || got.MaxZ != hm.MaxZ {
+		t.Errorf("got %+v, want %+v", got, hm)
+	} else if got.WrapX || got.WrapY || got.Metadata != nil {
+		t.Errorf("got wrap %v %v and metadata %v, want none", got.WrapX, got.WrapY, got.Metadata)
+	}
+
+	// a column of one elevation for every column allowed, plus one
+	tooWide := `{"Data":[` + strings.Repeat(`[0],`, maxDimension) + `[0]]}`
+	for _, tc := range []struct {
+		name string
+		json string
+	}{
+		{"not json", `{"Data":`},
+		{"null", `null`},
+		{"no data", `{"MinZ":0,"MaxZ":1}`},
+		{"empty column", `{"Data":[[]]}`},
+		{"ragged", `{"Data":[[0,1],[2]]}`},
+		{"ragged and longer", `{"Data":[[0],[1,2]]}`},
+		{"too wide", tooWide},
+	} {
+		if _, err := Decode(strings.NewReader(tc.json)); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestDecodeBadHeader(t *testing.T) {
+	valid := header{Magic: magic, Version: formatVersion, Width: 2, Height: 2, DataType: uint8(Float64)}
+	for _, tc := range []struct {
+		name   string
+		change func(h *header)
+	}{
+		{"no magic", func(h *header) { h.Magic = [4]byte{'M', 'G', 'H', 'X'} }},
+		{"version", func(h *header) { h.Version = formatVersion + 1 }},
+		{"data type", func(h *header) { h.DataType = 0 }},
+		{"unknown data type", func(h *header) { h.DataType = 9 }},
+		{"orientation", func(h *header) { h.Orientation = 2 }},
+		{"no width", func(h *header) { h.Width = 0 }},
+		{"no height", func(h *header) { h.Height = 0 }},
+		{"too wide", func(h *header) { h.Width = maxDimension + 1 }},
+		{"too tall", func(h *header) { h.Height = math.MaxUint32 }},
+		{"too many pixels", func(h *header) { h.Width, h.Height = maxDimension, maxDimension }},
+		{"metadata too long", func(h *header) { h.MetaLength = math.MaxUint32 }},
+		{"missing metadata", func(h *header) { h.MetaLength = 10 }},
+		{"missing elevations", func(h *header) {}},
+	} {
+		h := valid
+		tc.change(&h)
+		var bb bytes.Buffer
+		if err := binary.Write(&bb, binary.LittleEndian, h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Decode(&bb); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	for _, opts := range []EncodeOptions{
+		{DataType: Float64},
+		{DataType: Uint16, Compress: true},
+	} {
+		hm := codecMap()
+		hm.Metadata = &Metadata{Generator: "flat", Seed: 7}
+		var bb bytes.Buffer
+		if err := hm.Encode(&bb, opts); err != nil {
+			t.Fatal(err)
+		}
+		// every prefix of the file, from nothing up to all but the last byte
+		for n := 0; n < bb.Len(); n++ {
+			if _, err := Decode(bytes.NewReader(bb.Bytes()[:n])); err == nil {
+				t.Errorf("%+v: %d of %d bytes: expected an error", opts, n, bb.Len())
+			}
+		}
+	}
+}
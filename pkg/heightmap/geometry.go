@@ -0,0 +1,139 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import "fmt"
+
+// The geometric transforms return a new map and do not change the original.
+// Y increases from the top of the map to the bottom, so a clockwise turn
+// moves the top edge of the map to the right edge.
+
+// RotateBy returns the map rotated by a multiple of 90 degrees.
+// Positive angles turn the map clockwise and negative angles turn it counterclockwise.
+func (hm *Map) RotateBy(degrees int) (*Map, error) {
+	if degrees%90 != 0 {
+		return nil, fmt.Errorf("%d: must be a multiple of 90 degrees", degrees)
+	}
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	var nm *Map
+	// number of clockwise quarter turns, 0...3
+	switch ((degrees/90)%4 + 4) % 4 {
+	case 0:
+		nm = newMap(hm, maxx, maxy)
+		for x := 0; x < maxx; x++ {
+			copy(nm.Data[x], hm.Data[x])
+		}
+	case 1:
+		nm = newMap(hm, maxy, maxx)
+		nm.WrapX, nm.WrapY = hm.WrapY, hm.WrapX
+		for x := 0; x < maxy; x++ {
+			for y := 0; y < maxx; y++ {
+				nm.Data[x][y] = hm.Data[y][maxy-1-x]
+			}
+		}
+	case 2:
+		nm = newMap(hm, maxx, maxy)
+		for x := 0; x < maxx; x++ {
+			for y := 0; y < maxy; y++ {
+				nm.Data[x][y] = hm.Data[maxx-1-x][maxy-1-y]
+			}
+		}
+	case 3:
+		nm = newMap(hm, maxy, maxx)
+		nm.WrapX, nm.WrapY = hm.WrapY, hm.WrapX
+		for x := 0; x < maxy; x++ {
+			for y := 0; y < maxx; y++ {
+				nm.Data[x][y] = hm.Data[maxx-1-y][x]
+			}
+		}
+	}
+	return nm, nil
+}
+
+// FlipHorizontal returns the map mirrored left to right.
+func (hm *Map) FlipHorizontal() *Map {
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	nm := newMap(hm, maxx, maxy)
+	for x := 0; x < maxx; x++ {
+		copy(nm.Data[x], hm.Data[maxx-1-x])
+	}
+	return nm
+}
+
+// FlipVertical returns the map mirrored top to bottom.
+func (hm *Map) FlipVertical() *Map {
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	nm := newMap(hm, maxx, maxy)
+	for x := 0; x < maxx; x++ {
+		for y := 0; y < maxy; y++ {
+			nm.Data[x][y] = hm.Data[x][maxy-1-y]
+		}
+	}
+	return nm
+}
+
+// Crop returns the width x height rectangle of the map with its top left corner at x0, y0.
+// The rectangle must be inside the map.
+func (hm *Map) Crop(x0, y0, width, height int) (*Map, error) {
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	if x0 < 0 || y0 < 0 || width < 1 || height < 1 || x0+width > maxx || y0+height > maxy {
+		return nil, fmt.Errorf("crop: rectangle is outside the %dx%d map", maxx, maxy)
+	}
+	nm := newMap(hm, width, height)
+	// a cropped map only wraps if nothing was cut from that axis
+	nm.WrapX, nm.WrapY = hm.WrapX && width == maxx, hm.WrapY && height == maxy
+	for x := 0; x < width; x++ {
+		copy(nm.Data[x], hm.Data[x0+x][y0:y0+height])
+	}
+	return nm, nil
+}
+
+// Pad returns the map with extra pixels added to each side.
+// The new pixels are set to the fill elevation, which must be between MinZ and MaxZ
+// so that the map stays normalized.
+func (hm *Map) Pad(left, top, right, bottom int, fill float64) (*Map, error) {
+	if left < 0 || top < 0 || right < 0 || bottom < 0 {
+		return nil, fmt.Errorf("pad: padding must not be negative")
+	} else if fill < hm.MinZ || fill > hm.MaxZ {
+		return nil, fmt.Errorf("pad: fill must be between %g and %g", hm.MinZ, hm.MaxZ)
+	}
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	width, height := left+maxx+right, top+maxy+bottom
+	nm := newMap(hm, width, height)
+	nm.WrapX, nm.WrapY = hm.WrapX && left+right == 0, hm.WrapY && top+bottom == 0
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			if ox, oy := x-left, y-top; 0 <= ox && ox < maxx && 0 <= oy && oy < maxy {
+				nm.Data[x][y] = hm.Data[ox][oy]
+			} else {
+				nm.Data[x][y] = fill
+			}
+		}
+	}
+	return nm, nil
+}
+
+// newMap returns a map with the same elevation range, wrapping, and metadata as hm,
+// but with room for maxx by maxy elevations.
+func newMap(hm *Map, maxx, maxy int) *Map {
+	nm := &Map{MinZ: hm.MinZ, MaxZ: hm.MaxZ, WrapX: hm.WrapX, WrapY: hm.WrapY, Metadata: hm.Metadata, Data: make([][]float64, maxx, maxx)}
+	data := make([]float64, maxx*maxy)
+	for x := 0; x < maxx; x++ {
+		nm.Data[x] = data[x*maxy : (x+1)*maxy]
+	}
+	return nm
+}
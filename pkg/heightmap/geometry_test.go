@@ -0,0 +1,205 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testMap returns a 3x2 map that wraps left to right, where the elevation
+// of each pixel is 10*x + y. Drawn as rows, it is
+//
+//	0 10 20
+//	1 11 21
+func testMap() *Map {
+	hm := &Map{MinZ: 0, MaxZ: 21, WrapX: true, Data: make([][]float64, 3)}
+	for x := range hm.Data {
+		hm.Data[x] = make([]float64, 2)
+		for y := range hm.Data[x] {
+			hm.Data[x][y] = float64(10*x + y)
+		}
+	}
+	return hm
+}
+
+// rows returns the elevations of the map as rows, from the top down,
+// which is how the expected maps are written.
+func rows(hm *Map) [][]float64 {
+	r := make([][]float64, len(hm.Data[0]))
+	for y := range r {
+		for x := range hm.Data {
+			r[y] = append(r[y], hm.Data[x][y])
+		}
+	}
+	return r
+}
+
+func TestRotateBy(t *testing.T) {
+	for _, tc := range []struct {
+		degrees      int
+		want         [][]float64
+		wrapX, wrapY bool
+	}{
+		{0, [][]float64{{0, 10, 20}, {1, 11, 21}}, true, false},
+		{90, [][]float64{{1, 0}, {11, 10}, {21, 20}}, false, true},
+		{180, [][]float64{{21, 11, 1}, {20, 10, 0}}, true, false},
+		{270, [][]float64{{20, 21}, {10, 11}, {0, 1}}, false, true},
+		{-90, [][]float64{{20, 21}, {10, 11}, {0, 1}}, false, true},
+		{-270, [][]float64{{1, 0}, {11, 10}, {21, 20}}, false, true},
+		{360, [][]float64{{0, 10, 20}, {1, 11, 21}}, true, false},
+	} {
+		hm := testMap()
+		nm, err := hm.RotateBy(tc.degrees)
+		if err != nil {
+			t.Errorf("rotate %d: unexpected error %v", tc.degrees, err)
+			continue
+		}
+		if got := rows(nm); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("rotate %d: got %v, want %v", tc.degrees, got, tc.want)
+		}
+		if nm.WrapX != tc.wrapX || nm.WrapY != tc.wrapY {
+			t.Errorf("rotate %d: got wrap %v %v, want %v %v", tc.degrees, nm.WrapX, nm.WrapY, tc.wrapX, tc.wrapY)
+		}
+		if !reflect.DeepEqual(rows(hm), rows(testMap())) {
+			t.Errorf("rotate %d: changed the original map", tc.degrees)
+		}
+	}
+
+	for _, degrees := range []int{45, 1, -100} {
+		if _, err := testMap().RotateBy(degrees); err == nil {
+			t.Errorf("rotate %d: expected an error", degrees)
+		}
+	}
+}
+
+func TestCompose(t *testing.T) {
+	// turn returns a step that rotates the map
+	turn := func(degrees int) func(*Map) *Map {
+		return func(hm *Map) *Map {
+			nm, err := hm.RotateBy(degrees)
+			if err != nil {
+				t.Fatalf("rotate %d: unexpected error %v", degrees, err)
+			}
+			return nm
+		}
+	}
+	flipH, flipV := (*Map).FlipHorizontal, (*Map).FlipVertical
+
+	for _, tc := range []struct {
+		name  string
+		steps []func(*Map) *Map
+		want  func(*Map) *Map
+	}{
+		{"four clockwise turns", []func(*Map) *Map{turn(90), turn(90), turn(90), turn(90)}, nil},
+		{"four counterclockwise turns", []func(*Map) *Map{turn(-90), turn(-90), turn(-90), turn(-90)}, nil},
+		{"clockwise then counterclockwise", []func(*Map) *Map{turn(90), turn(-90)}, nil},
+		{"counterclockwise then clockwise", []func(*Map) *Map{turn(-90), turn(90)}, nil},
+		{"two clockwise turns", []func(*Map) *Map{turn(90), turn(90)}, turn(180)},
+		{"three clockwise turns", []func(*Map) *Map{turn(90), turn(90), turn(90)}, turn(-90)},
+		{"flip horizontal twice", []func(*Map) *Map{flipH, flipH}, nil},
+		{"flip vertical twice", []func(*Map) *Map{flipV, flipV}, nil},
+		{"flip horizontal then vertical", []func(*Map) *Map{flipH, flipV}, turn(180)},
+		{"flip vertical then horizontal", []func(*Map) *Map{flipV, flipH}, turn(180)},
+	} {
+		got := testMap()
+		for _, step := range tc.steps {
+			got = step(got)
+		}
+		want := testMap()
+		if tc.want != nil {
+			want = tc.want(want)
+		}
+		if !reflect.DeepEqual(rows(got), rows(want)) {
+			t.Errorf("%s: got %v, want %v", tc.name, rows(got), rows(want))
+		}
+		if got.WrapX != want.WrapX || got.WrapY != want.WrapY {
+			t.Errorf("%s: got wrap %v %v, want %v %v", tc.name, got.WrapX, got.WrapY, want.WrapX, want.WrapY)
+		}
+	}
+}
+
+func TestCrop(t *testing.T) {
+	for _, tc := range []struct {
+		x0, y0, width, height int
+		want                  [][]float64 // nil if the crop is rejected
+		wrapX                 bool
+	}{
+		{0, 0, 3, 2, [][]float64{{0, 10, 20}, {1, 11, 21}}, true},
+		{1, 0, 2, 2, [][]float64{{10, 20}, {11, 21}}, false},
+		{0, 1, 3, 1, [][]float64{{1, 11, 21}}, true},
+		{2, 1, 1, 1, [][]float64{{21}}, false},
+		{-1, 0, 2, 2, nil, false},
+		{0, -1, 2, 2, nil, false},
+		{2, 0, 2, 2, nil, false},
+		{0, 1, 3, 2, nil, false},
+		{0, 0, 0, 2, nil, false},
+		{0, 0, 3, 0, nil, false},
+	} {
+		nm, err := testMap().Crop(tc.x0, tc.y0, tc.width, tc.height)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("crop %d %d %d %d: expected an error", tc.x0, tc.y0, tc.width, tc.height)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("crop %d %d %d %d: unexpected error %v", tc.x0, tc.y0, tc.width, tc.height, err)
+			continue
+		}
+		if got := rows(nm); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("crop %d %d %d %d: got %v, want %v", tc.x0, tc.y0, tc.width, tc.height, got, tc.want)
+		}
+		if nm.WrapX != tc.wrapX {
+			t.Errorf("crop %d %d %d %d: got wrap x %v, want %v", tc.x0, tc.y0, tc.width, tc.height, nm.WrapX, tc.wrapX)
+		}
+	}
+}
+
+func TestPad(t *testing.T) {
+	for _, tc := range []struct {
+		left, top, right, bottom int
+		fill                     float64
+		want                     [][]float64 // nil if the padding is rejected
+		wrapX                    bool
+	}{
+		{0, 0, 0, 0, 0, [][]float64{{0, 10, 20}, {1, 11, 21}}, true},
+		{1, 0, 0, 0, 5, [][]float64{{5, 0, 10, 20}, {5, 1, 11, 21}}, false},
+		{0, 1, 0, 1, 21, [][]float64{{21, 21, 21}, {0, 10, 20}, {1, 11, 21}, {21, 21, 21}}, true},
+		{0, 0, 1, 1, 0, [][]float64{{0, 10, 20, 0}, {1, 11, 21, 0}, {0, 0, 0, 0}}, false},
+		{-1, 0, 0, 0, 0, nil, false},
+		{0, 0, 0, -1, 0, nil, false},
+		{1, 1, 1, 1, -1, nil, false},
+		{1, 1, 1, 1, 22, nil, false},
+	} {
+		nm, err := testMap().Pad(tc.left, tc.top, tc.right, tc.bottom, tc.fill)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("pad %d %d %d %d %g: expected an error", tc.left, tc.top, tc.right, tc.bottom, tc.fill)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("pad %d %d %d %d %g: unexpected error %v", tc.left, tc.top, tc.right, tc.bottom, tc.fill, err)
+			continue
+		}
+		if got := rows(nm); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("pad %d %d %d %d %g: got %v, want %v", tc.left, tc.top, tc.right, tc.bottom, tc.fill, got, tc.want)
+		}
+		if nm.WrapX != tc.wrapX || nm.WrapY {
+			t.Errorf("pad %d %d %d %d %g: got wrap %v %v, want %v false", tc.left, tc.top, tc.right, tc.bottom, tc.fill, nm.WrapX, nm.WrapY, tc.wrapX)
+		}
+	}
+}
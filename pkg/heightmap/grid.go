@@ -0,0 +1,117 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Grid holds a value for every pixel of a map, indexed as [x][y] like Map.Data.
+// Pixels without a value are NaN.
+type Grid [][]float64
+
+// newGrid returns a grid of zeros.
+func newGrid(maxx, maxy int) Grid {
+	g := make(Grid, maxx)
+	data := make([]float64, maxx*maxy)
+	for x := range g {
+		g[x] = data[x*maxy : (x+1)*maxy]
+	}
+	return g
+}
+
+// Range returns the smallest and largest values in the grid.
+// Both are NaN if no pixel has a value.
+func (g Grid) Range() (lo, hi float64) {
+	lo, hi = math.Inf(1), math.Inf(-1)
+	for _, col := range g {
+		for _, v := range col {
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+	}
+	if lo > hi {
+		return math.NaN(), math.NaN()
+	}
+	return lo, hi
+}
+
+// Ramps for drawing grids.
+// The colors are spread evenly from the lowest value to the highest.
+var (
+	// GrayRamp runs from black to white.
+	GrayRamp = []color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}}
+	// SequentialRamp runs from dark purple through green to yellow, for values that only go up.
+	SequentialRamp = []color.RGBA{{68, 1, 84, 255}, {59, 82, 139, 255}, {33, 145, 140, 255}, {94, 201, 98, 255}, {253, 231, 37, 255}}
+	// DivergingRamp runs from blue through white to red, for values above and below zero.
+	DivergingRamp = []color.RGBA{{33, 102, 172, 255}, {146, 197, 222, 255}, {247, 247, 247, 255}, {244, 165, 130, 255}, {178, 24, 43, 255}}
+	// CyclicRamp runs around the color wheel and back to where it started, for angles.
+	CyclicRamp = []color.RGBA{{230, 60, 60, 255}, {230, 200, 60, 255}, {60, 200, 90, 255}, {60, 130, 230, 255}, {170, 80, 220, 255}, {230, 60, 60, 255}}
+	// TemperatureRamp runs from purple and blue for the cold through yellow to red for the heat.
+	TemperatureRamp = []color.RGBA{{94, 60, 153, 255}, {69, 117, 180, 255}, {145, 191, 219, 255}, {255, 255, 191, 255}, {253, 174, 97, 255}, {215, 48, 39, 255}}
+	// PrecipitationRamp runs from the tan of the desert through green to blue for the wettest.
+	PrecipitationRamp = []color.RGBA{{222, 200, 150, 255}, {190, 210, 130, 255}, {90, 170, 90, 255}, {40, 130, 150, 255}, {30, 60, 150, 255}}
+)
+
+// noValue is the color of pixels without a value.
+var noValue = color.RGBA{128, 128, 128, 255}
+
+// Image draws the grid with the colors of the ramp.
+// Values at or below lo get the first color and values at or above hi get the last.
+func (g Grid) Image(lo, hi float64, ramp []color.RGBA) *image.RGBA {
+	maxx, maxy := len(g), len(g[0])
+	img := image.NewRGBA(image.Rect(0, 0, maxx, maxy))
+	for x := 0; x < maxx; x++ {
+		for y := 0; y < maxy; y++ {
+			v := g[x][y]
+			if math.IsNaN(v) {
+				img.Set(x, y, noValue)
+				continue
+			}
+			t := 0.0
+			if hi > lo {
+				t = math.Max(0, math.Min(1, (v-lo)/(hi-lo)))
+			}
+			img.Set(x, y, rampColor(ramp, t))
+		}
+	}
+	return img
+}
+
+// rampColor returns the color at t, from 0 to 1, along the ramp.
+func rampColor(ramp []color.RGBA, t float64) color.RGBA {
+	if len(ramp) == 1 {
+		return ramp[0]
+	}
+	pos := t * float64(len(ramp)-1)
+	i := int(pos)
+	if i >= len(ramp)-1 {
+		return ramp[len(ramp)-1]
+	}
+	f := pos - float64(i)
+	a, b := ramp[i], ramp[i+1]
+	mix := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a)*(1-f) + float64(b)*f))
+	}
+	return color.RGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: mix(a.A, b.A)}
+}
@@ -31,12 +31,48 @@ type Map struct {
 	Data [][]float64
 	// Colors is the index into the color table for each pixel
 	Colors [][]int
-	ctab   []color.RGBA
+	// Metadata records how the map was created.
+	// It may be nil for maps loaded from legacy files.
+	Metadata *Metadata `json:",omitempty"`
+	// WrapX is set if the left and right edges of the map join seamlessly,
+	// as they do on a cylindrical world map.
+	// WrapY is set if the top and bottom edges join.
+	WrapX, WrapY bool `json:",omitempty"`
+	ctab         []color.RGBA
 }
 
+// Copy returns a deep copy of the map.
+// Transforms change the map in place, so a map that is shared
+// (for example, by a cache) must be copied before it is transformed.
+func (hm *Map) Copy() *Map {
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	cp := &Map{MinZ: hm.MinZ, MaxZ: hm.MaxZ, WrapX: hm.WrapX, WrapY: hm.WrapY, Data: make([][]float64, maxx, maxx)}
+	data := make([]float64, maxx*maxy)
+	for x := 0; x < maxx; x++ {
+		cp.Data[x] = data[x*maxy : (x+1)*maxy]
+		copy(cp.Data[x], hm.Data[x])
+	}
+	if hm.Colors != nil {
+		cp.Colors = make([][]int, len(hm.Colors))
+		for x := range hm.Colors {
+			cp.Colors[x] = append([]int(nil), hm.Colors[x]...)
+		}
+	}
+	cp.Metadata = hm.Metadata.Copy()
+	cp.ctab = append([]color.RGBA(nil), hm.ctab...)
+	return cp
+}
+
+// Rotate turns the map a quarter turn clockwise or counterclockwise.
+// Unlike RotateBy, it changes the map.
 func (hm *Map) Rotate(clockwise bool) {
-	rm := FromArray(hm.Data, YXOrientation, true)
-	hm.Data = rm.Data
+	degrees := 90
+	if !clockwise {
+		degrees = -90
+	}
+	rm, _ := hm.RotateBy(degrees)
+	hm.Data, hm.Colors = rm.Data, nil
+	hm.WrapX, hm.WrapY = rm.WrapX, rm.WrapY
 }
 
 func (hm *Map) ShiftXY(dx, dy int) {
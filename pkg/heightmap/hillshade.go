@@ -0,0 +1,91 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"fmt"
+	"math"
+)
+
+// Light is the light used to shade the terrain.
+type Light struct {
+	// Azimuth is the direction the light comes from, in degrees clockwise from north (the top of the map).
+	Azimuth float64
+	// Altitude is the angle of the light above the horizon, in degrees.
+	Altitude float64
+	// Exaggeration multiplies the height of the terrain.
+	// At 1, the terrain has the DefaultScale of the map.
+	Exaggeration float64
+	// MultiDirectional adds light from either side of the azimuth,
+	// so that slopes facing away from the light still show detail.
+	MultiDirectional bool
+}
+
+// DefaultLight is the conventional light from the northwest.
+var DefaultLight = Light{Azimuth: 315, Altitude: 45, Exaggeration: 1}
+
+// Validate returns an error if the light can't be used.
+func (l Light) Validate() error {
+	if l.Altitude <= 0 || l.Altitude > 90 {
+		return fmt.Errorf("altitude: must be greater than 0 and at most 90 degrees")
+	} else if l.Exaggeration <= 0 {
+		return fmt.Errorf("exaggeration: must be greater than 0")
+	}
+	return nil
+}
+
+// Hillshade returns the brightness of every pixel, from 0 (in shadow) to 1 (facing the light).
+func (hm *Map) Hillshade(light Light) Grid {
+	sc := hm.DefaultScale()
+	sc.Relief *= light.Exaggeration
+
+	// the lights and their direction as unit vectors, with x to the east,
+	// y to the south, and z up. the multi-directional lights match GDAL.
+	azimuths := []float64{light.Azimuth}
+	if light.MultiDirectional {
+		azimuths = []float64{light.Azimuth - 90, light.Azimuth - 45, light.Azimuth, light.Azimuth + 45}
+	}
+	alt := light.Altitude * math.Pi / 180
+	lx, ly, lz := make([]float64, len(azimuths)), make([]float64, len(azimuths)), math.Sin(alt)
+	for i, az := range azimuths {
+		az *= math.Pi / 180
+		lx[i], ly[i] = math.Sin(az)*math.Cos(alt), -math.Cos(az)*math.Cos(alt)
+	}
+
+	shade := newGrid(len(hm.Data), len(hm.Data[0]))
+	hm.neighborhood(sc, func(x, y int, z *window) {
+		// the normal to the surface
+		dzdx, dzdy := z.gradient()
+		nx, ny, nz := -dzdx, -dzdy, 1.0
+		length := math.Sqrt(nx*nx + ny*ny + nz*nz)
+		nx, ny, nz = nx/length, ny/length, nz/length
+		if len(azimuths) == 1 {
+			shade[x][y] = math.Max(0, nx*lx[0]+ny*ly[0]+nz*lz)
+			return
+		}
+		// weight each light by how square it is to the downhill direction.
+		// the weights of lights 45 degrees apart add up to 2.
+		aspect := math.Atan2(nx, -ny)
+		var sum float64
+		for i, az := range azimuths {
+			w := math.Sin(aspect - az*math.Pi/180)
+			sum += w * w * math.Max(0, nx*lx[i]+ny*ly[i]+nz*lz)
+		}
+		shade[x][y] = sum / 2
+	})
+	return shade
+}
@@ -0,0 +1,465 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"container/heap"
+	"encoding/json"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// SeaLevel returns the elevation that pctWater percent of the pixels are below.
+// It matches the water drawn by Color and ColorHSL.
+func (hm *Map) SeaLevel(pctWater int) float64 {
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	if pctWater <= 0 {
+		return hm.MinZ
+	} else if pctWater >= 100 {
+		return math.Nextafter(hm.MaxZ, math.Inf(1))
+	}
+	values := make([]float64, 0, maxx*maxy)
+	for x := range hm.Data {
+		values = append(values, hm.Data[x]...)
+	}
+	sort.Float64s(values)
+	return values[pctWater*len(values)/100]
+}
+
+// d8 are the offsets to the eight pixels around a pixel and the distance to each.
+var d8 = [8]struct {
+	dx, dy int
+	d      float64
+}{
+	{-1, -1, math.Sqrt2}, {0, -1, 1}, {1, -1, math.Sqrt2},
+	{-1, 0, 1}, {1, 0, 1},
+	{-1, 1, math.Sqrt2}, {0, 1, 1}, {1, 1, math.Sqrt2},
+}
+
+// Drainage is the path that water takes across a map.
+// Water runs downhill, through any lakes, until it reaches the ocean or
+// the edge of the map; on an edge that wraps, it continues on the other side.
+type Drainage struct {
+	// SeaLevel is the elevation below which pixels are water.
+	SeaLevel float64
+	// Ocean is set for the water that is part of the ocean: the water that
+	// touches an edge of the map that doesn't wrap, and the largest body of water.
+	// Other water is in Lakes.
+	Ocean [][]bool
+	// Filled is the elevation of every pixel after every pit is filled,
+	// so that water can run from every pixel to the ocean or an edge.
+	// Filled pixels are raised a tiny amount above the pixel they drain to,
+	// so that water runs across flat ground.
+	Filled Grid
+	// Direction is the index into the eight neighbors of the pixel that
+	// each pixel drains to, starting at the northwest and reading across
+	// and down. It is -1 for pixels in the ocean and for pixels that drain
+	// off an edge of the map.
+	Direction [][]int8
+	// Accumulation is the number of pixels that drain through each pixel,
+	// including the pixel itself. It is 0 for the ocean.
+	Accumulation Grid
+	// Lakes are the bodies of water that don't reach the ocean.
+	Lakes []Lake
+	// LakeID is the index into Lakes of every pixel in a lake, or -1.
+	LakeID [][]int32
+
+	hm   *Map
+	maxx int
+	maxy int
+}
+
+// cell is a pixel in the priority queue of Drainage.
+type cell struct {
+	z    float64
+	x, y int
+}
+
+// cellQueue is a priority queue that returns the lowest cell first.
+type cellQueue []cell
+
+func (q cellQueue) Len() int            { return len(q) }
+func (q cellQueue) Less(i, j int) bool  { return q[i].z < q[j].z }
+func (q cellQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *cellQueue) Push(v interface{}) { *q = append(*q, v.(cell)) }
+func (q *cellQueue) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// Drainage fills the pits in the map with the priority-flood method,
+// points every pixel that isn't in the ocean at its steepest neighbor
+// downhill (D8), and counts the pixels that drain through each pixel.
+// Pixels below seaLevel are water, in either the ocean or a lake.
+// When flood is set, every basin is flooded up to the elevation where it
+// spills over, and the water is added to the lakes.
+func (hm *Map) Drainage(seaLevel float64, flood bool) *Drainage {
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	d := &Drainage{
+		SeaLevel:     seaLevel,
+		Ocean:        make([][]bool, maxx),
+		Filled:       newGrid(maxx, maxy),
+		Direction:    make([][]int8, maxx),
+		Accumulation: newGrid(maxx, maxy),
+		hm:           hm,
+		maxx:         maxx,
+		maxy:         maxy,
+	}
+	for x := range d.Direction {
+		d.Direction[x] = make([]int8, maxy)
+		for y := range d.Direction[x] {
+			d.Direction[x][y] = -1
+		}
+	}
+	d.findOcean()
+
+	// the flood starts from the ocean and from the edges that don't wrap.
+	// if there aren't any, it starts from the lowest pixel.
+	done := make([][]bool, maxx)
+	for x := range done {
+		done[x] = make([]bool, maxy)
+	}
+	var q cellQueue
+	lowest := cell{z: math.Inf(1)}
+	for x := 0; x < maxx; x++ {
+		for y := 0; y < maxy; y++ {
+			z := hm.Data[x][y]
+			if d.Ocean[x][y] || d.isEdge(x, y) {
+				q = append(q, cell{z: z, x: x, y: y})
+				done[x][y] = true
+			} else if z < lowest.z {
+				lowest = cell{z: z, x: x, y: y}
+			}
+		}
+	}
+	if len(q) == 0 {
+		q = append(q, lowest)
+		done[lowest.x][lowest.y] = true
+	}
+	heap.Init(&q)
+
+	// pixels leave the queue from lowest to highest filled elevation,
+	// which is the order that water runs in reverse.
+	order := make([]cell, 0, maxx*maxy)
+	for q.Len() != 0 {
+		c := heap.Pop(&q).(cell)
+		d.Filled[c.x][c.y] = c.z
+		order = append(order, c)
+		for _, nb := range d8 {
+			nx, ny, ok := d.neighbor(c.x, c.y, nb.dx, nb.dy)
+			if !ok || done[nx][ny] {
+				continue
+			}
+			done[nx][ny] = true
+			z := hm.Data[nx][ny]
+			if z <= c.z {
+				z = math.Nextafter(c.z, math.Inf(1))
+			}
+			heap.Push(&q, cell{z: z, x: nx, y: ny})
+		}
+	}
+
+	// every pixel that isn't on an edge has a lower neighbor
+	// that left the queue before it did. pixels on an edge drain off the map.
+	for _, c := range order {
+		if d.Ocean[c.x][c.y] {
+			continue
+		}
+		d.Accumulation[c.x][c.y] = 1
+		if d.isEdge(c.x, c.y) {
+			continue
+		}
+		z, steepest := d.Filled[c.x][c.y], 0.0
+		for n, nb := range d8 {
+			nx, ny, ok := d.neighbor(c.x, c.y, nb.dx, nb.dy)
+			if !ok {
+				continue
+			}
+			if drop := (z - d.Filled[nx][ny]) / nb.d; drop > steepest {
+				d.Direction[c.x][c.y], steepest = int8(n), drop
+			}
+		}
+	}
+	for n := len(order) - 1; n >= 0; n-- {
+		c := order[n]
+		if x, y, ok := d.Downstream(c.x, c.y); ok && !d.Ocean[x][y] {
+			d.Accumulation[x][y] += d.Accumulation[c.x][c.y]
+		}
+	}
+	d.findLakes(flood)
+	return d
+}
+
+// neighbor returns the pixel at the offset from x, y, wrapped onto the map.
+// Ok is false if it is off an edge that doesn't wrap.
+func (d *Drainage) neighbor(x, y, dx, dy int) (int, int, bool) {
+	x, y = x+dx, y+dy
+	if d.hm.WrapX {
+		x = index(x, d.maxx, true)
+	}
+	if d.hm.WrapY {
+		y = index(y, d.maxy, true)
+	}
+	return x, y, 0 <= x && x < d.maxx && 0 <= y && y < d.maxy
+}
+
+// isEdge returns true if the pixel is on an edge of the map that doesn't wrap.
+func (d *Drainage) isEdge(x, y int) bool {
+	return (!d.hm.WrapX && (x == 0 || x == d.maxx-1)) || (!d.hm.WrapY && (y == 0 || y == d.maxy-1))
+}
+
+// Downstream returns the pixel that x, y drains to.
+// Ok is false if the pixel is in the ocean or drains off the map.
+func (d *Drainage) Downstream(x, y int) (int, int, bool) {
+	n := d.Direction[x][y]
+	if n < 0 {
+		return x, y, false
+	}
+	return d.neighbor(x, y, d8[n].dx, d8[n].dy)
+}
+
+// RiverPoint is a pixel along a river.
+type RiverPoint struct {
+	X, Y int
+	// Flow is the number of pixels that drain through the point.
+	Flow float64
+}
+
+// River is a line of pixels from a source to the ocean, to a lake,
+// to the edge of the map, or to the river that it flows into.
+// A river that leaves a lake starts at the lake's outlet.
+// A river that crosses the edge of a map that wraps continues past
+// the edge, so the coordinates of its points may be less than zero
+// or greater than the size of the map.
+type River struct {
+	Points []RiverPoint
+}
+
+// Rivers returns the rivers that drain at least threshold pixels.
+// At each confluence, the river with the larger flow continues
+// and the others end.
+func (d *Drainage) Rivers(threshold float64) []River {
+	isRiver := func(x, y int) bool {
+		return !d.Ocean[x][y] && d.LakeID[x][y] < 0 && d.Accumulation[x][y] >= threshold
+	}
+	// main is the pixel with the largest flow that drains into each river pixel
+	type source struct {
+		x, y int
+		flow float64
+	}
+	main := make(map[[2]int]source)
+	for x := 0; x < d.maxx; x++ {
+		for y := 0; y < d.maxy; y++ {
+			if !isRiver(x, y) {
+				continue
+			}
+			if nx, ny, ok := d.Downstream(x, y); ok {
+				key := [2]int{nx, ny}
+				if s, ok := main[key]; !ok || d.Accumulation[x][y] > s.flow {
+					main[key] = source{x: x, y: y, flow: d.Accumulation[x][y]}
+				}
+			}
+		}
+	}
+
+	var rivers []River
+	for x := 0; x < d.maxx; x++ {
+		for y := 0; y < d.maxy; y++ {
+			if _, ok := main[[2]int{x, y}]; ok || !isRiver(x, y) {
+				continue
+			}
+			// follow the river from its source
+			var r River
+			cx, cy, px, py := x, y, x, y
+			for {
+				r.Points = append(r.Points, RiverPoint{X: px, Y: py, Flow: d.Accumulation[cx][cy]})
+				nx, ny, ok := d.Downstream(cx, cy)
+				if !ok {
+					break
+				}
+				// keep the points next to each other across an edge that wraps
+				n := d8[d.Direction[cx][cy]]
+				px, py = px+n.dx, py+n.dy
+				if !isRiver(nx, ny) || main[[2]int{nx, ny}] != (source{x: cx, y: cy, flow: d.Accumulation[cx][cy]}) {
+					// the river reaches the ocean or a lake, or joins a larger river
+					r.Points = append(r.Points, RiverPoint{X: px, Y: py, Flow: d.Accumulation[nx][ny]})
+					break
+				}
+				cx, cy = nx, ny
+			}
+			if len(r.Points) > 1 {
+				rivers = append(rivers, r)
+			}
+		}
+	}
+	return rivers
+}
+
+// RiverColor is the color rivers are drawn in.
+var RiverColor = color.RGBA{R: 0, G: 102, B: 204, A: 255}
+
+// maxRiverWidth is the widest (in pixels) that a river is drawn.
+const maxRiverWidth = 6
+
+// riverWidth returns the width (in pixels) of a river with the flow.
+// Rivers at the threshold are a pixel wide, and get half a pixel wider
+// each time their flow doubles.
+func riverWidth(flow, threshold float64) float64 {
+	return math.Min(maxRiverWidth, 1+math.Log2(math.Max(1, flow/threshold))/2)
+}
+
+// drawRivers draws the rivers onto the image, which is the size of the map.
+func (d *Drainage) drawRivers(img *image.RGBA, rivers []River, threshold float64) {
+	// cover is how much of each pixel is covered by a river, from 0 to 1.
+	// keeping the largest cover stops overlapping segments from darkening the joints.
+	cover := newGrid(d.maxx, d.maxy)
+	// segments that cross an edge that wraps are drawn again on the other side
+	xOffsets, yOffsets := []float64{0}, []float64{0}
+	if d.hm.WrapX {
+		xOffsets = append(xOffsets, -float64(d.maxx), float64(d.maxx))
+	}
+	if d.hm.WrapY {
+		yOffsets = append(yOffsets, -float64(d.maxy), float64(d.maxy))
+	}
+	for _, r := range rivers {
+		for n := 1; n < len(r.Points); n++ {
+			a, b := r.Points[n-1], r.Points[n]
+			radius := riverWidth(a.Flow, threshold) / 2
+			// rivers may cross an edge more than once, so the segment is
+			// first moved back to the copy of the map that its start is on
+			sx := math.Floor(float64(a.X)/float64(d.maxx)) * float64(d.maxx)
+			sy := math.Floor(float64(a.Y)/float64(d.maxy)) * float64(d.maxy)
+			for _, dx := range xOffsets {
+				for _, dy := range yOffsets {
+					ax, ay := float64(a.X)+0.5+dx-sx, float64(a.Y)+0.5+dy-sy
+					bx, by := float64(b.X)+0.5+dx-sx, float64(b.Y)+0.5+dy-sy
+					x0, x1 := int(math.Floor(math.Min(ax, bx)-radius-1)), int(math.Ceil(math.Max(ax, bx)+radius+1))
+					y0, y1 := int(math.Floor(math.Min(ay, by)-radius-1)), int(math.Ceil(math.Max(ay, by)+radius+1))
+					for x := imax(x0, 0); x < imin(x1, d.maxx); x++ {
+						for y := imax(y0, 0); y < imin(y1, d.maxy); y++ {
+							dist := segmentDistance(float64(x)+0.5, float64(y)+0.5, ax, ay, bx, by)
+							cover[x][y] = math.Max(cover[x][y], math.Max(0, math.Min(1, radius+0.5-dist)))
+						}
+					}
+				}
+			}
+		}
+	}
+	for x := 0; x < d.maxx; x++ {
+		for y := 0; y < d.maxy; y++ {
+			if f := cover[x][y]; f > 0 {
+				c := img.RGBAAt(x, y)
+				mix := func(a, b uint8) uint8 {
+					return uint8(math.Round(float64(a)*(1-f) + float64(b)*f))
+				}
+				img.SetRGBA(x, y, color.RGBA{R: mix(c.R, RiverColor.R), G: mix(c.G, RiverColor.G), B: mix(c.B, RiverColor.B), A: 255})
+			}
+		}
+	}
+}
+
+// segmentDistance returns the distance from the point to the line segment from a to b.
+func segmentDistance(px, py, ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if l2 := dx*dx + dy*dy; l2 > 0 {
+		t = math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/l2))
+	}
+	return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func imin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// GeoJSON returns the rivers that drain at least threshold pixels and
+// the lakes as a GeoJSON FeatureCollection.
+// Rivers are LineStrings, with their flow (in pixels) at the mouth and at
+// each point; there are none if threshold is 0.
+// Lakes are Polygons or MultiPolygons that trace the edges of their pixels,
+// with their area (in pixels) and the elevations of their surface, their
+// spill point, and their deepest point.
+// Coordinates are in pixels, with x to the right and y down, and pixel
+// centers at half a pixel.
+func (d *Drainage) GeoJSON(threshold float64) ([]byte, error) {
+	type geometry struct {
+		Type        string      `json:"type"`
+		Coordinates interface{} `json:"coordinates"`
+	}
+	type feature struct {
+		Type       string                 `json:"type"`
+		Geometry   geometry               `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+	type collection struct {
+		Type     string    `json:"type"`
+		Width    int       `json:"width"`
+		Height   int       `json:"height"`
+		SeaLevel float64   `json:"seaLevel"`
+		Features []feature `json:"features"`
+	}
+	fc := collection{Type: "FeatureCollection", Width: d.maxx, Height: d.maxy, SeaLevel: d.SeaLevel, Features: []feature{}}
+	if threshold > 0 {
+		for _, r := range d.Rivers(threshold) {
+			var coords [][2]float64
+			var flows []float64
+			for _, p := range r.Points {
+				coords = append(coords, [2]float64{float64(p.X) + 0.5, float64(p.Y) + 0.5})
+				flows = append(flows, p.Flow)
+			}
+			fc.Features = append(fc.Features, feature{
+				Type:       "Feature",
+				Geometry:   geometry{Type: "LineString", Coordinates: coords},
+				Properties: map[string]interface{}{"kind": "river", "flow": r.Points[len(r.Points)-2].Flow, "flows": flows},
+			})
+		}
+	}
+	for id, polygons := range d.lakeOutlines() {
+		lake := d.Lakes[id]
+		g := geometry{Type: "MultiPolygon", Coordinates: polygons}
+		if len(polygons) == 1 {
+			g = geometry{Type: "Polygon", Coordinates: polygons[0]}
+		}
+		fc.Features = append(fc.Features, feature{
+			Type:     "Feature",
+			Geometry: g,
+			Properties: map[string]interface{}{
+				"kind":    "lake",
+				"lake":    id,
+				"area":    lake.Pixels,
+				"surface": lake.Surface,
+				"spill":   lake.Spill,
+				"bottom":  lake.Bottom,
+			},
+		})
+	}
+	return json.Marshal(fc)
+}
@@ -0,0 +1,114 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"image"
+	"testing"
+)
+
+func TestDrainageRamp(t *testing.T) {
+	// a 6x5 map that slopes down to the east, with no water
+	hm := &Map{MaxZ: 1, Data: newGrid(6, 5)}
+	for x := range hm.Data {
+		for y := range hm.Data[x] {
+			hm.Data[x][y] = float64(5-x) / 5
+		}
+	}
+	d := hm.Drainage(-1, false)
+	for x := 0; x < 6; x++ {
+		for y := 0; y < 5; y++ {
+			if d.Ocean[x][y] {
+				t.Errorf("%d %d: got water, want land", x, y)
+			}
+			// pixels on the edges drain off the map; the rest drain east,
+			// gathering the water from the pixels to the west of them
+			wantDirection, wantFlow := int8(-1), 1.0
+			if x != 0 && x != 5 && y != 0 && y != 4 {
+				wantDirection = 4
+			}
+			if x != 0 && y != 0 && y != 4 {
+				wantFlow = float64(x)
+			}
+			if got := d.Direction[x][y]; got != wantDirection {
+				t.Errorf("%d %d: got direction %d, want %d", x, y, got, wantDirection)
+			}
+			if got := d.Accumulation[x][y]; got != wantFlow {
+				t.Errorf("%d %d: got flow %g, want %g", x, y, got, wantFlow)
+			}
+		}
+	}
+
+	// each row inside the map is a river that runs off the east edge
+	rivers := d.Rivers(2)
+	if len(rivers) != 3 {
+		t.Fatalf("got %d rivers, want 3", len(rivers))
+	}
+	for _, r := range rivers {
+		first, last := r.Points[0], r.Points[len(r.Points)-1]
+		if len(r.Points) != 4 || first.X != 2 || first.Flow != 2 || last.X != 5 || last.Y != first.Y || last.Flow != 5 {
+			t.Errorf("got river %+v, want one from 2 to 5 along a row", r.Points)
+		}
+	}
+
+	// when the map wraps, the west edge drains across it to the bottom of the ramp
+	hm.WrapX = true
+	d = hm.Drainage(-1, false)
+	for y := 1; y < 4; y++ {
+		if got := d.Direction[0][y]; got != 3 {
+			t.Errorf("wrap: 0 %d: got direction %d, want 3", y, got)
+		}
+		if x, y2, ok := d.Downstream(0, y); !ok || x != 5 || y2 != y {
+			t.Errorf("wrap: 0 %d: got downstream %d %d %v, want 5 %d", y, x, y2, ok, y)
+		}
+	}
+}
+
+func TestDrawRiversAcrossEdges(t *testing.T) {
+	type point struct{ x, y int }
+	for _, tc := range []struct {
+		name         string
+		wrapX, wrapY bool
+		a, b         point   // the ends of the river
+		drawn        []point // pixels the river must cover
+		clear        []point // pixels the river must not cover
+	}{
+		{"inside", false, false, point{3, 2}, point{3, 3}, []point{{3, 2}, {3, 3}}, []point{{3, 0}, {0, 2}}},
+		{"off the bottom", false, false, point{3, 7}, point{3, 8}, []point{{3, 7}}, []point{{3, 0}}},
+		{"wraps bottom to top", false, true, point{3, 7}, point{3, 8}, []point{{3, 7}, {3, 0}}, nil},
+		{"wraps top to bottom", false, true, point{3, 0}, point{3, -1}, []point{{3, 0}, {3, 7}}, nil},
+		{"wraps right to left", true, false, point{7, 3}, point{8, 3}, []point{{7, 3}, {0, 3}}, []point{{3, 0}}},
+		{"wraps twice", false, true, point{3, 15}, point{3, 16}, []point{{3, 7}, {3, 0}}, []point{{3, 3}}},
+		{"wraps at a corner", true, true, point{7, 7}, point{8, 8}, []point{{7, 7}, {0, 0}}, nil},
+	} {
+		hm := &Map{MaxZ: 1, WrapX: tc.wrapX, WrapY: tc.wrapY, Data: newGrid(8, 8)}
+		d := &Drainage{hm: hm, maxx: 8, maxy: 8}
+		img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		river := River{Points: []RiverPoint{{X: tc.a.x, Y: tc.a.y, Flow: 1}, {X: tc.b.x, Y: tc.b.y, Flow: 1}}}
+		d.drawRivers(img, []River{river}, 1)
+		for _, p := range tc.drawn {
+			if img.RGBAAt(p.x, p.y) != RiverColor {
+				t.Errorf("%s: %d %d: got %v, want the river", tc.name, p.x, p.y, img.RGBAAt(p.x, p.y))
+			}
+		}
+		for _, p := range tc.clear {
+			if img.RGBAAt(p.x, p.y) == RiverColor {
+				t.Errorf("%s: %d %d: got the river, want nothing", tc.name, p.x, p.y)
+			}
+		}
+	}
+}
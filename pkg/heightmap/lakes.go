@@ -0,0 +1,313 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"image/color"
+	"math"
+)
+
+// Lake is a body of water that doesn't reach the ocean.
+type Lake struct {
+	// Pixels is the area of the lake.
+	Pixels int
+	// Surface is the elevation of the water. It is the sea level,
+	// or the spill point if the basin was flooded.
+	Surface float64
+	// Spill is the elevation where the water overflows the basin
+	// and runs toward the ocean.
+	Spill float64
+	// Bottom is the elevation of the deepest pixel.
+	Bottom float64
+}
+
+// LakeColors are the colors of lakes, from the shallows to the deepest water.
+var LakeColors = []color.RGBA{
+	{R: 150, G: 215, B: 210, A: 255},
+	{R: 110, G: 190, B: 195, A: 255},
+	{R: 75, G: 160, B: 180, A: 255},
+	{R: 45, G: 130, B: 160, A: 255},
+	{R: 25, G: 100, B: 140, A: 255},
+}
+
+// label numbers the groups of pixels that are in the mask and touch each
+// other, including at the corners and across the edges that wrap.
+// It returns the group of every pixel, or -1 if the pixel isn't in the mask,
+// and the number of pixels in each group.
+func (d *Drainage) label(mask func(x, y int) bool) ([][]int32, []int) {
+	ids := make([][]int32, d.maxx)
+	for x := range ids {
+		ids[x] = make([]int32, d.maxy)
+		for y := range ids[x] {
+			ids[x][y] = -1
+		}
+	}
+	var sizes []int
+	var stack [][2]int
+	for x := 0; x < d.maxx; x++ {
+		for y := 0; y < d.maxy; y++ {
+			if ids[x][y] >= 0 || !mask(x, y) {
+				continue
+			}
+			id := int32(len(sizes))
+			sizes = append(sizes, 0)
+			ids[x][y] = id
+			stack = append(stack[:0], [2]int{x, y})
+			for len(stack) != 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				sizes[id]++
+				for _, nb := range d8 {
+					nx, ny, ok := d.neighbor(p[0], p[1], nb.dx, nb.dy)
+					if ok && ids[nx][ny] < 0 && mask(nx, ny) {
+						ids[nx][ny] = id
+						stack = append(stack, [2]int{nx, ny})
+					}
+				}
+			}
+		}
+	}
+	return ids, sizes
+}
+
+// findOcean sets Ocean for the bodies of water that touch an edge that
+// doesn't wrap and for the largest body of water.
+func (d *Drainage) findOcean() {
+	ids, sizes := d.label(func(x, y int) bool {
+		return d.hm.Data[x][y] < d.SeaLevel
+	})
+	ocean := make([]bool, len(sizes))
+	largest := -1
+	for id, n := range sizes {
+		if largest < 0 || n > sizes[largest] {
+			largest = id
+		}
+	}
+	if largest >= 0 {
+		ocean[largest] = true
+	}
+	for x := 0; x < d.maxx; x++ {
+		d.Ocean[x] = make([]bool, d.maxy)
+		for y := 0; y < d.maxy; y++ {
+			if id := ids[x][y]; id >= 0 && d.isEdge(x, y) {
+				ocean[id] = true
+			}
+		}
+	}
+	for x := 0; x < d.maxx; x++ {
+		for y := 0; y < d.maxy; y++ {
+			if id := ids[x][y]; id >= 0 && ocean[id] {
+				d.Ocean[x][y] = true
+			}
+		}
+	}
+}
+
+// findLakes finds the water that isn't part of the ocean.
+// When flood is set, basins are filled to their spill points.
+func (d *Drainage) findLakes(flood bool) {
+	// flat ground is filled by a tiny amount so that water runs across it;
+	// that isn't a lake
+	minDepth := (d.hm.MaxZ - d.hm.MinZ) * 1e-6
+	ids, sizes := d.label(func(x, y int) bool {
+		if d.Ocean[x][y] {
+			return false
+		} else if z := d.hm.Data[x][y]; z < d.SeaLevel {
+			return true
+		} else {
+			return flood && d.Filled[x][y]-z > minDepth
+		}
+	})
+	d.LakeID, d.Lakes = ids, make([]Lake, len(sizes))
+	for id := range d.Lakes {
+		d.Lakes[id] = Lake{Pixels: sizes[id], Spill: math.Inf(1), Bottom: math.Inf(1)}
+	}
+	for x := 0; x < d.maxx; x++ {
+		for y := 0; y < d.maxy; y++ {
+			if id := ids[x][y]; id >= 0 {
+				lake := &d.Lakes[id]
+				lake.Spill = math.Min(lake.Spill, d.Filled[x][y])
+				lake.Bottom = math.Min(lake.Bottom, d.hm.Data[x][y])
+			}
+		}
+	}
+	for id := range d.Lakes {
+		if d.Lakes[id].Surface = d.SeaLevel; flood {
+			d.Lakes[id].Surface = d.Lakes[id].Spill
+		}
+	}
+}
+
+// colorLakes adds the LakeColors to the color table of the map
+// and colors the lakes by their depth.
+func (hm *Map) colorLakes(d *Drainage) {
+	base := len(hm.ctab)
+	hm.ctab = append(hm.ctab, LakeColors...)
+	for x := range d.LakeID {
+		for y, id := range d.LakeID[x] {
+			if id < 0 {
+				continue
+			}
+			lake, t := d.Lakes[id], 0.0
+			if depth := lake.Surface - lake.Bottom; depth > 0 {
+				t = (lake.Surface - hm.Data[x][y]) / depth
+			}
+			n := int(t * float64(len(LakeColors)))
+			if n < 0 {
+				n = 0
+			} else if n >= len(LakeColors) {
+				n = len(LakeColors) - 1
+			}
+			hm.Colors[x][y] = base + n
+		}
+	}
+}
+
+// edge is a side of a pixel on the outline of a lake,
+// running from a to b with the lake on the right.
+type edge struct {
+	ax, ay, bx, by int
+}
+
+// lakeOutlines returns the outline of every lake as a list of polygons.
+// Each polygon is a ring around the outside of the lake followed by a ring
+// around each island, and each ring ends where it starts.
+// Rings run counterclockwise around the lake, as GeoJSON expects,
+// if the y axis points up.
+// Lakes that cross an edge of the map that wraps are split at the edge.
+func (d *Drainage) lakeOutlines() [][][][][2]float64 {
+	edges := make([][]edge, len(d.Lakes))
+	lakeAt := func(id int32, x, y int) bool {
+		return 0 <= x && x < d.maxx && 0 <= y && y < d.maxy && d.LakeID[x][y] == id
+	}
+	for x := 0; x < d.maxx; x++ {
+		for y := 0; y < d.maxy; y++ {
+			id := d.LakeID[x][y]
+			if id < 0 {
+				continue
+			}
+			if !lakeAt(id, x, y-1) {
+				edges[id] = append(edges[id], edge{x, y, x + 1, y})
+			}
+			if !lakeAt(id, x+1, y) {
+				edges[id] = append(edges[id], edge{x + 1, y, x + 1, y + 1})
+			}
+			if !lakeAt(id, x, y+1) {
+				edges[id] = append(edges[id], edge{x + 1, y + 1, x, y + 1})
+			}
+			if !lakeAt(id, x-1, y) {
+				edges[id] = append(edges[id], edge{x, y + 1, x, y})
+			}
+		}
+	}
+
+	outlines := make([][][][][2]float64, len(d.Lakes))
+	for id := range edges {
+		var outers, holes [][][2]float64
+		for _, ring := range traceRings(edges[id]) {
+			if ringArea(ring) > 0 {
+				outers = append(outers, ring)
+			} else {
+				holes = append(holes, ring)
+			}
+		}
+		polygons := make([][][][2]float64, len(outers))
+		for n, ring := range outers {
+			polygons[n] = [][][2]float64{ring}
+		}
+		for _, hole := range holes {
+			// a point just inside the lake, next to the first side of the hole
+			a, b := hole[0], hole[1]
+			dx, dy := math.Copysign(math.Min(1, math.Abs(b[0]-a[0])), b[0]-a[0]), math.Copysign(math.Min(1, math.Abs(b[1]-a[1])), b[1]-a[1])
+			px, py := a[0]+dx/2-dy/4, a[1]+dy/2+dx/4
+			for n, ring := range outers {
+				if insideRing(px, py, ring) {
+					polygons[n] = append(polygons[n], hole)
+					break
+				}
+			}
+		}
+		outlines[id] = polygons
+	}
+	return outlines
+}
+
+// traceRings joins the edges into closed rings.
+// Where two corners of the lake touch, the ring turns left to go around
+// both pixels, which keeps lakes that touch at a corner in one piece.
+// Points in the middle of a straight side are left out.
+func traceRings(edges []edge) [][][2]float64 {
+	from := make(map[[2]int][]int)
+	for n, e := range edges {
+		key := [2]int{e.ax, e.ay}
+		from[key] = append(from[key], n)
+	}
+	used := make([]bool, len(edges))
+	var rings [][][2]float64
+	for start := range edges {
+		if used[start] {
+			continue
+		}
+		var ring [][2]float64
+		for n := start; !used[n]; {
+			used[n] = true
+			e := edges[n]
+			next := -1
+			for _, m := range from[[2]int{e.bx, e.by}] {
+				// with the y axis down, a left turn has a negative cross product
+				f := edges[m]
+				if cross := (e.bx-e.ax)*(f.by-f.ay) - (e.by-e.ay)*(f.bx-f.ax); next < 0 || cross < 0 {
+					next = m
+				}
+			}
+			// keep the corners
+			if next >= 0 {
+				f := edges[next]
+				if (e.bx-e.ax) != (f.bx-f.ax) || (e.by-e.ay) != (f.by-f.ay) {
+					ring = append(ring, [2]float64{float64(e.bx), float64(e.by)})
+				}
+				n = next
+			}
+		}
+		if len(ring) >= 3 {
+			rings = append(rings, append(ring, ring[0]))
+		}
+	}
+	return rings
+}
+
+// ringArea returns the signed area of the closed ring.
+// It is positive for rings that run counterclockwise if the y axis points up.
+func ringArea(ring [][2]float64) float64 {
+	var sum float64
+	for n := 1; n < len(ring); n++ {
+		sum += ring[n-1][0]*ring[n][1] - ring[n][0]*ring[n-1][1]
+	}
+	return sum / 2
+}
+
+// insideRing returns true if the point is inside the closed ring.
+func insideRing(px, py float64, ring [][2]float64) bool {
+	inside := false
+	for n := 1; n < len(ring); n++ {
+		a, b := ring[n-1], ring[n]
+		if (a[1] > py) != (b[1] > py) && px < a[0]+(py-a[1])*(b[0]-a[0])/(b[1]-a[1]) {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,115 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLakesFloodToSpill(t *testing.T) {
+	// a 6x5 map with a hollow two pixels long inside a rim of 0.5,
+	// with a gap in the rim at 0.4 to the east; the edges are at 0
+	hm := &Map{MaxZ: 1, Data: newGrid(6, 5)}
+	for x := 1; x < 5; x++ {
+		for y := 1; y < 4; y++ {
+			hm.Data[x][y] = 0.5
+		}
+	}
+	hm.Data[2][2], hm.Data[3][2], hm.Data[4][2] = 0.1, 0.15, 0.4
+	for _, tc := range []struct {
+		name     string
+		seaLevel float64
+		flood    bool
+		lakes    int
+		surface  float64
+	}{
+		{"dry", -1, false, 0, 0},
+		{"flooded", -1, true, 1, 0.4},
+		{"below the sea", 0.2, false, 1, 0.2},
+		{"below the sea and flooded", 0.2, true, 1, 0.4},
+	} {
+		d := hm.Drainage(tc.seaLevel, tc.flood)
+		if len(d.Lakes) != tc.lakes {
+			t.Errorf("%s: got %d lakes, want %d", tc.name, len(d.Lakes), tc.lakes)
+			continue
+		}
+		// the hollow drains through the gap in the rim whether or not it is flooded
+		if d.Direction[2][2] != 4 || d.Direction[3][2] != 4 || d.Accumulation[4][2] != 3 {
+			t.Errorf("%s: got directions %d %d and flow %g, want the hollow to drain east", tc.name, d.Direction[2][2], d.Direction[3][2], d.Accumulation[4][2])
+		}
+		if tc.lakes == 0 {
+			continue
+		}
+		lake := d.Lakes[0]
+		if lake.Pixels != 2 || d.LakeID[2][2] != 0 || d.LakeID[3][2] != 0 || d.LakeID[4][2] != -1 {
+			t.Errorf("%s: got a lake of %d pixels, want the 2 in the hollow", tc.name, lake.Pixels)
+		}
+		if math.Abs(lake.Surface-tc.surface) > 1e-9 || math.Abs(lake.Spill-0.4) > 1e-9 || lake.Bottom != 0.1 {
+			t.Errorf("%s: got surface %g, spill %g, bottom %g, want %g, 0.4, 0.1", tc.name, lake.Surface, lake.Spill, lake.Bottom, tc.surface)
+		}
+	}
+}
+
+func TestDrainageOcean(t *testing.T) {
+	// two hollows on a map that wraps both ways: the larger one is the ocean
+	hm := &Map{MaxZ: 1, WrapX: true, WrapY: true, Data: newGrid(6, 6)}
+	for x := range hm.Data {
+		for y := range hm.Data[x] {
+			hm.Data[x][y] = 1
+		}
+	}
+	hm.Data[0][0], hm.Data[1][0], hm.Data[0][1], hm.Data[1][1] = 0, 0, 0, 0
+	hm.Data[4][4] = 0.25
+	for _, tc := range []struct {
+		name      string
+		wrapX     bool
+		wantOcean [][2]int
+		wantLake  [][2]int
+	}{
+		{"wraps", true, [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}}, [][2]int{{4, 4}}},
+		// the smaller hollow doesn't touch an edge, so it is still a lake
+		{"edges", false, [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}}, [][2]int{{4, 4}}},
+	} {
+		hm.WrapX, hm.WrapY = tc.wrapX, tc.wrapX
+		d := hm.Drainage(0.5, false)
+		ocean, lakes := 0, 0
+		for x := range d.Ocean {
+			for y := range d.Ocean[x] {
+				if d.Ocean[x][y] {
+					ocean++
+				}
+				if d.LakeID[x][y] >= 0 {
+					lakes++
+				}
+			}
+		}
+		if ocean != len(tc.wantOcean) || lakes != len(tc.wantLake) {
+			t.Errorf("%s: got %d ocean and %d lake pixels, want %d and %d", tc.name, ocean, lakes, len(tc.wantOcean), len(tc.wantLake))
+		}
+		for _, p := range tc.wantOcean {
+			if !d.Ocean[p[0]][p[1]] || d.Direction[p[0]][p[1]] != -1 || d.Accumulation[p[0]][p[1]] != 0 {
+				t.Errorf("%s: %v: want ocean", tc.name, p)
+			}
+		}
+		for _, p := range tc.wantLake {
+			if d.Ocean[p[0]][p[1]] || d.LakeID[p[0]][p[1]] != 0 {
+				t.Errorf("%s: %v: want the first lake", tc.name, p)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Metadata records the provenance of a map.
+// Running the same generator with the same parameters and seed
+// recreates the map exactly.
+type Metadata struct {
+	Generator string            `json:"generator,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+	Seed      int64             `json:"seed"`
+	Version   string            `json:"version,omitempty"`
+	// Created is when the map was created.
+	Created time.Time `json:"created,omitempty"`
+	// Elapsed is the time it took to generate the map.
+	Elapsed time.Duration `json:"elapsed,omitempty"`
+	// Processing lists the changes made to the map after it was generated, in order.
+	Processing []string `json:"processing,omitempty"`
+}
+
+// ID returns the identifier for the map.
+// It includes the generator so that maps from different generators
+// that use the same seed don't overwrite each other.
+func (m *Metadata) ID() string {
+	if m.Generator == "" {
+		return fmt.Sprintf("%d", m.Seed)
+	}
+	return fmt.Sprintf("%d-%s", m.Seed, m.Generator)
+}
+
+// SameAs returns true if both maps were created by the same generator,
+// parameters, and seed.
+func (m *Metadata) SameAs(other *Metadata) bool {
+	if m == nil || other == nil {
+		return m == other
+	} else if m.Generator != other.Generator || m.Seed != other.Seed || len(m.Params) != len(other.Params) {
+		return false
+	}
+	for k, v := range m.Params {
+		if ov, ok := other.Params[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+// CommandLine returns the command that recreates the map.
+func (m *Metadata) CommandLine() string {
+	var keys []string
+	for k := range m.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := []string{"mapgen", "generate", m.Generator, fmt.Sprintf("--seed=%d", m.Seed)}
+	for _, k := range keys {
+		args = append(args, fmt.Sprintf("--%s=%s", k, m.Params[k]))
+	}
+	return strings.Join(args, " ")
+}
+
+// Copy returns a copy of the metadata that shares nothing with the original.
+// It returns nil if the metadata is nil.
+func (m *Metadata) Copy() *Metadata {
+	if m == nil {
+		return nil
+	}
+	meta := *m
+	if m.Params != nil {
+		meta.Params = make(map[string]string, len(m.Params))
+		for k, v := range m.Params {
+			meta.Params[k] = v
+		}
+	}
+	meta.Processing = append([]string(nil), m.Processing...)
+	return &meta
+}
+
+// Processed returns a copy of the metadata with the step added to the processing.
+// Maps without metadata get metadata that only records the step.
+func (m *Metadata) Processed(step string) *Metadata {
+	meta := m.Copy()
+	if meta == nil {
+		meta = &Metadata{}
+	}
+	meta.Processing = append(meta.Processing, step)
+	return meta
+}
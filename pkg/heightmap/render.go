@@ -19,7 +19,9 @@ package heightmap
 import (
 	"bytes"
 	"image"
+	"image/color"
 	"image/png"
+	"math"
 )
 
 func (hm *Map) AsImage() (*image.RGBA, error) {
@@ -41,3 +43,30 @@ func (hm *Map) AsPNG() ([]byte, error) {
 	}
 	return bb.Bytes(), nil
 }
+
+// asShadedImage draws the map with its colors, made darker or lighter by the shade.
+// Level ground has a shade of flat and keeps its color.
+// Blend is how much of the shading is applied, from 0 (none) to 1 (all).
+func (hm *Map) asShadedImage(shade Grid, flat, blend float64) *image.RGBA {
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	img := image.NewRGBA(image.Rect(0, 0, maxx, maxy))
+	for x := 0; x < maxx; x++ {
+		for y := 0; y < maxy; y++ {
+			c, f := hm.ctab[hm.Colors[x][y]], 1+blend*(shade[x][y]/flat-1)
+			img.Set(x, y, color.RGBA{R: scaleColor(c.R, f), G: scaleColor(c.G, f), B: scaleColor(c.B, f), A: c.A})
+		}
+	}
+	return img
+}
+
+func scaleColor(c uint8, f float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(float64(c)*f))))
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	bb := &bytes.Buffer{}
+	if err := png.Encode(bb, img); err != nil {
+		return nil, err
+	}
+	return bb.Bytes(), nil
+}
@@ -0,0 +1,276 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"fmt"
+	"math"
+)
+
+// Interpolation is the method used to find the elevation between pixels.
+type Interpolation int
+
+const (
+	// Nearest uses the elevation of the closest pixel.
+	Nearest Interpolation = iota
+	// Bilinear blends the four closest pixels.
+	Bilinear
+	// Bicubic fits a Catmull-Rom spline through the sixteen closest pixels.
+	// It is smoother than Bilinear but can overshoot near sharp changes.
+	Bicubic
+	// Lanczos uses a windowed sinc over the thirty-six closest pixels.
+	// It keeps the most detail, especially when reducing a map.
+	Lanczos
+)
+
+var interpolationNames = []string{"nearest", "bilinear", "bicubic", "lanczos"}
+
+// ParseInterpolation returns the interpolation with the given name.
+func ParseInterpolation(name string) (Interpolation, error) {
+	for i, s := range interpolationNames {
+		if s == name {
+			return Interpolation(i), nil
+		}
+	}
+	return Nearest, fmt.Errorf("%q: unknown interpolation", name)
+}
+
+func (i Interpolation) String() string {
+	if 0 <= int(i) && int(i) < len(interpolationNames) {
+		return interpolationNames[i]
+	}
+	return fmt.Sprintf("Interpolation(%d)", int(i))
+}
+
+// sampler returns elevations at fractional coordinates.
+// Pixel (i, j) is centered at x = i, y = j.
+// Coordinates outside the map wrap around when wrapX or wrapY is set
+// and are clamped to the nearest edge otherwise.
+type sampler struct {
+	hm           *Map
+	maxx, maxy   int
+	wrapX, wrapY bool
+}
+
+func newSampler(hm *Map, wrapX, wrapY bool) *sampler {
+	return &sampler{hm: hm, maxx: len(hm.Data), maxy: len(hm.Data[0]), wrapX: wrapX, wrapY: wrapY}
+}
+
+// at returns the elevation of the pixel, wrapping or clamping the coordinates.
+func (s *sampler) at(x, y int) float64 {
+	return s.hm.Data[index(x, s.maxx, s.wrapX)][index(y, s.maxy, s.wrapY)]
+}
+
+// index returns i wrapped into (or clamped to) the range 0...n-1.
+func index(i, n int, wrap bool) int {
+	if wrap {
+		if i %= n; i < 0 {
+			i += n
+		}
+		return i
+	} else if i < 0 {
+		return 0
+	} else if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// sample returns the elevation at x, y.
+// Results are clamped to the elevation range of the map, so that
+// bicubic overshoot doesn't break normalization.
+func (s *sampler) sample(x, y float64, method Interpolation) float64 {
+	var z float64
+	switch method {
+	case Bilinear:
+		x0, y0 := math.Floor(x), math.Floor(y)
+		fx, fy := x-x0, y-y0
+		i, j := int(x0), int(y0)
+		top := s.at(i, j)*(1-fx) + s.at(i+1, j)*fx
+		bottom := s.at(i, j+1)*(1-fx) + s.at(i+1, j+1)*fx
+		z = top*(1-fy) + bottom*fy
+	case Bicubic:
+		x0, y0 := math.Floor(x), math.Floor(y)
+		fx, fy := x-x0, y-y0
+		i, j := int(x0), int(y0)
+		var col [4]float64
+		for n := -1; n <= 2; n++ {
+			col[n+1] = cubic(s.at(i-1, j+n), s.at(i, j+n), s.at(i+1, j+n), s.at(i+2, j+n), fx)
+		}
+		z = cubic(col[0], col[1], col[2], col[3], fy)
+	case Lanczos:
+		x0, y0 := math.Floor(x), math.Floor(y)
+		i, j := int(x0), int(y0)
+		var wx, wy [6]float64
+		var sum float64
+		for n := range wx {
+			wx[n], wy[n] = lanczos3(x-x0-float64(n-2)), lanczos3(y-y0-float64(n-2))
+		}
+		for n := range wx {
+			for m := range wy {
+				w := wx[n] * wy[m]
+				z, sum = z+w*s.at(i+n-2, j+m-2), sum+w
+			}
+		}
+		z /= sum
+	default:
+		return s.at(int(math.Floor(x+0.5)), int(math.Floor(y+0.5)))
+	}
+	if z < s.hm.MinZ {
+		return s.hm.MinZ
+	} else if z > s.hm.MaxZ {
+		return s.hm.MaxZ
+	}
+	return z
+}
+
+// cubic interpolates between p1 and p2 with a Catmull-Rom spline.
+func cubic(p0, p1, p2, p3, t float64) float64 {
+	return p1 + 0.5*t*(p2-p0+t*(2*p0-5*p1+4*p2-p3+t*(3*(p1-p2)+p3-p0)))
+}
+
+// lanczos3 is the Lanczos kernel with a window of three pixels.
+func lanczos3(t float64) float64 {
+	if t == 0 {
+		return 1
+	} else if t <= -3 || t >= 3 {
+		return 0
+	}
+	t *= math.Pi
+	return 3 * math.Sin(t) * math.Sin(t/3) / (t * t)
+}
+
+// Shift returns the map moved right by dx and down by dy pixels.
+// The offsets may be fractional; the method is used to find elevations between pixels.
+// The map wraps in X if WrapX is set, which is how a cylindrical world map is scrolled.
+// It wraps in Y if wrapY is set, whether or not the map does.
+// Edges that don't wrap are stretched to fill the gap.
+func (hm *Map) Shift(dx, dy float64, wrapY bool, method Interpolation) *Map {
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	s := newSampler(hm, hm.WrapX, wrapY)
+	nm := newMap(hm, maxx, maxy)
+	for x := 0; x < maxx; x++ {
+		for y := 0; y < maxy; y++ {
+			nm.Data[x][y] = s.sample(float64(x)-dx, float64(y)-dy, method)
+		}
+	}
+	return nm
+}
+
+// filter is a kernel used to resize maps.
+// The kernel is zero outside of -support...support.
+type filter struct {
+	support float64
+	kernel  func(t float64) float64
+}
+
+var filters = map[Interpolation]filter{
+	Bilinear: {1, func(t float64) float64 {
+		return math.Max(0, 1-math.Abs(t))
+	}},
+	// the Catmull-Rom spline used by sample, written as a kernel
+	Bicubic: {2, func(t float64) float64 {
+		if t = math.Abs(t); t < 1 {
+			return 1.5*t*t*t - 2.5*t*t + 1
+		} else if t < 2 {
+			return -0.5*t*t*t + 2.5*t*t - 4*t + 2
+		}
+		return 0
+	}},
+	Lanczos: {3, lanczos3},
+}
+
+// tap is a source pixel and its weight.
+type tap struct {
+	i int
+	w float64
+}
+
+// taps returns the source pixels that contribute to each pixel when a row
+// of src pixels is resized to dst pixels.
+// When reducing, the kernel is stretched to cover every source pixel so that
+// detail is averaged rather than skipped.
+// Pixels beyond the ends of the row wrap around if wrap is set.
+func taps(src, dst int, wrap bool, f filter) [][]tap {
+	scale := float64(dst) / float64(src)
+	stretch := 1.0
+	if scale < 1 {
+		stretch = 1 / scale
+	}
+	support := f.support * stretch
+	row := make([][]tap, dst)
+	for j := range row {
+		center := (float64(j)+0.5)/scale - 0.5
+		var sum float64
+		for i := int(math.Ceil(center - support)); i <= int(math.Floor(center+support)); i++ {
+			if w := f.kernel((float64(i) - center) / stretch); w != 0 {
+				row[j] = append(row[j], tap{i: index(i, src, wrap), w: w})
+				sum += w
+			}
+		}
+		for n := range row[j] {
+			row[j][n].w /= sum
+		}
+	}
+	return row
+}
+
+// Resize returns a copy of the map with the given width and height.
+// Maps that wrap are resized without a seam: pixels on one edge are
+// blended with pixels from the other edge.
+func (hm *Map) Resize(width, height int, method Interpolation) (*Map, error) {
+	if width < 1 || height < 1 {
+		return nil, fmt.Errorf("%dx%d: invalid size", width, height)
+	}
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	nm := newMap(hm, width, height)
+
+	f, ok := filters[method]
+	if !ok {
+		for x := 0; x < width; x++ {
+			ox := (2*x + 1) * maxx / (2 * width)
+			for y := 0; y < height; y++ {
+				nm.Data[x][y] = hm.Data[ox][(2*y+1)*maxy/(2*height)]
+			}
+		}
+		return nm, nil
+	}
+
+	// the kernels are separable, so resize the rows and then the columns
+	cols := taps(maxx, width, hm.WrapX, f)
+	tmp := make([][]float64, width)
+	for x, tt := range cols {
+		tmp[x] = make([]float64, maxy)
+		for _, t := range tt {
+			for y, z := range hm.Data[t.i] {
+				tmp[x][y] += t.w * z
+			}
+		}
+	}
+	rows := taps(maxy, height, hm.WrapY, f)
+	for x := range nm.Data {
+		for y, tt := range rows {
+			var z float64
+			for _, t := range tt {
+				z += t.w * tmp[x][t.i]
+			}
+			// bicubic and lanczos can overshoot near sharp changes
+			nm.Data[x][y] = math.Max(hm.MinZ, math.Min(hm.MaxZ, z))
+		}
+	}
+	return nm, nil
+}
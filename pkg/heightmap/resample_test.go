@@ -0,0 +1,129 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+var methods = []Interpolation{Nearest, Bilinear, Bicubic, Lanczos}
+
+func TestParseInterpolation(t *testing.T) {
+	for _, m := range methods {
+		if got, err := ParseInterpolation(m.String()); err != nil || got != m {
+			t.Errorf("%s: got %v %v, want %v", m, got, err, m)
+		}
+	}
+	if _, err := ParseInterpolation("cubist"); err == nil {
+		t.Errorf("cubist: expected an error")
+	}
+}
+
+// near returns true if the elevations of the maps are within 1e-9 of each other.
+func near(a, b [][]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for x := range a {
+		if len(a[x]) != len(b[x]) {
+			return false
+		}
+		for y := range a[x] {
+			if math.Abs(a[x][y]-b[x][y]) > 1e-9 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestResize(t *testing.T) {
+	// a row that steps from 0 to 1
+	step := func(wrapX bool) *Map {
+		return &Map{MaxZ: 1, WrapX: wrapX, Data: [][]float64{{0}, {0}, {0}, {1}}}
+	}
+	// a map where every elevation is 0.25
+	flat := func() *Map {
+		hm := &Map{MaxZ: 1, Data: newGrid(5, 3)}
+		for x := range hm.Data {
+			for y := range hm.Data[x] {
+				hm.Data[x][y] = 0.25
+			}
+		}
+		return hm
+	}
+
+	for _, m := range methods {
+		// the same size is the same map
+		if nm, err := testMap().Resize(3, 2, m); err != nil {
+			t.Errorf("%s: same size: unexpected error %v", m, err)
+		} else if !near(nm.Data, testMap().Data) {
+			t.Errorf("%s: same size: got %v, want %v", m, rows(nm), rows(testMap()))
+		}
+
+		// flat maps stay flat at any size
+		for _, size := range [][2]int{{10, 6}, {2, 1}, {7, 2}, {1, 1}} {
+			nm, err := flat().Resize(size[0], size[1], m)
+			if err != nil {
+				t.Errorf("%s: flat %v: unexpected error %v", m, size, err)
+				continue
+			}
+			want := newGrid(size[0], size[1])
+			for x := range want {
+				for y := range want[x] {
+					want[x][y] = 0.25
+				}
+			}
+			if !near(nm.Data, want) {
+				t.Errorf("%s: flat %v: got %v", m, size, rows(nm))
+			}
+		}
+
+		// the result never leaves the range of the map, even where bicubic and lanczos overshoot
+		nm, _ := step(false).Resize(16, 1, m)
+		for x := range nm.Data {
+			if z := nm.Data[x][0]; z < 0 || z > 1 {
+				t.Errorf("%s: step: %d: got %g, want 0 to 1", m, x, z)
+			}
+		}
+
+		// pixels on the left edge of a map that wraps are blended with the right edge
+		wrapped, _ := step(true).Resize(8, 1, m)
+		unwrapped, _ := step(false).Resize(8, 1, m)
+		if m != Nearest && wrapped.Data[0][0] <= 0 {
+			t.Errorf("%s: wrapped: got %g at the left edge, want more than 0", m, wrapped.Data[0][0])
+		} else if unwrapped.Data[0][0] != 0 {
+			t.Errorf("%s: not wrapped: got %g at the left edge, want 0", m, unwrapped.Data[0][0])
+		}
+
+		if _, err := testMap().Resize(0, 2, m); err == nil {
+			t.Errorf("%s: 0x2: expected an error", m)
+		}
+	}
+
+	// nearest copies pixels
+	nm, err := testMap().Resize(6, 4, Nearest)
+	if err != nil {
+		t.Fatalf("nearest: unexpected error %v", err)
+	}
+	want := [][]float64{{0, 0, 10, 10, 20, 20}, {0, 0, 10, 10, 20, 20}, {1, 1, 11, 11, 21, 21}, {1, 1, 11, 11, 21, 21}}
+	if !reflect.DeepEqual(rows(nm), want) {
+		t.Errorf("nearest: got %v, want %v", rows(nm), want)
+	}
+}
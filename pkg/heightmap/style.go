@@ -0,0 +1,261 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Mode is the way a map is drawn.
+type Mode string
+
+const (
+	// ModeColor draws each pixel in the color for its elevation.
+	ModeColor Mode = "color"
+	// ModeHillshade draws the terrain in gray, lit by the light.
+	ModeHillshade Mode = "hillshade"
+	// ModeShaded draws the colors for the elevations, shaded by the light.
+	ModeShaded Mode = "shaded"
+	// ModeSlope draws the Slope of the terrain.
+	ModeSlope Mode = "slope"
+	// ModeAspect draws the Aspect of the terrain.
+	ModeAspect Mode = "aspect"
+	// ModeProfileCurvature draws the ProfileCurvature of the terrain.
+	ModeProfileCurvature Mode = "profile-curvature"
+	// ModePlanCurvature draws the PlanCurvature of the terrain.
+	ModePlanCurvature Mode = "plan-curvature"
+	// ModeRoughness draws the Roughness of the terrain.
+	ModeRoughness Mode = "roughness"
+	// ModeTRI draws the TRI of the terrain.
+	ModeTRI Mode = "tri"
+	// ModeTemperature draws the Temperature of the Climate.
+	ModeTemperature Mode = "temperature"
+	// ModePrecipitation draws the Precipitation of the Climate.
+	ModePrecipitation Mode = "precipitation"
+	// ModeBiome draws the Biomes of the Climate in the BiomeColors.
+	ModeBiome Mode = "biome"
+)
+
+// Modes lists the ways a map can be drawn.
+var Modes = []Mode{ModeColor, ModeHillshade, ModeShaded, ModeSlope, ModeAspect, ModeProfileCurvature, ModePlanCurvature, ModeRoughness, ModeTRI, ModeTemperature, ModePrecipitation, ModeBiome}
+
+// layers are the modes that draw a Grid derived from the map.
+var layers = map[Mode]struct {
+	grid func(hm *Map, st Style) (Grid, error)
+	// span returns the values drawn with the first and last colors of the ramp
+	span func(g Grid) (lo, hi float64)
+	// ramp is the false color ramp for the layer
+	ramp []color.RGBA
+}{
+	ModeSlope:            {terrainLayer((*Map).Slope), upTo, SequentialRamp},
+	ModeAspect:           {terrainLayer(func(hm *Map, _ Scale) Grid { return hm.Aspect() }), func(Grid) (float64, float64) { return 0, 360 }, CyclicRamp},
+	ModeProfileCurvature: {terrainLayer((*Map).ProfileCurvature), aroundZero, DivergingRamp},
+	ModePlanCurvature:    {terrainLayer((*Map).PlanCurvature), aroundZero, DivergingRamp},
+	ModeRoughness:        {terrainLayer((*Map).Roughness), upTo, SequentialRamp},
+	ModeTRI:              {terrainLayer((*Map).TRI), upTo, SequentialRamp},
+	ModeTemperature:      {climateLayer(func(c *Climate) Grid { return c.Temperature }), func(Grid) (float64, float64) { return -30, 30 }, TemperatureRamp},
+	ModePrecipitation:    {climateLayer(func(c *Climate) Grid { return c.Precipitation }), func(Grid) (float64, float64) { return 0, 3000 }, PrecipitationRamp},
+}
+
+// terrainLayer returns a layer measured from the terrain, which is
+// made taller or flatter by the exaggeration of the light.
+func terrainLayer(fn func(hm *Map, sc Scale) Grid) func(hm *Map, st Style) (Grid, error) {
+	return func(hm *Map, st Style) (Grid, error) {
+		sc := hm.DefaultScale()
+		sc.Relief *= st.Light.Exaggeration
+		return fn(hm, sc), nil
+	}
+}
+
+// climateLayer returns a layer from the climate of the map.
+// The span is fixed, so that the same colors mean the same weather on every map.
+func climateLayer(fn func(c *Climate) Grid) func(hm *Map, st Style) (Grid, error) {
+	return func(hm *Map, st Style) (Grid, error) {
+		c, err := st.Climate(hm)
+		if err != nil {
+			return nil, err
+		}
+		return fn(c), nil
+	}
+}
+
+// upTo spans the values from zero to nearly the largest.
+// The largest few are left out so that a handful of cliffs don't wash out the rest of the map.
+func upTo(g Grid) (float64, float64) {
+	return 0, percentile(g, 0.99, math.Abs)
+}
+
+// aroundZero spans the values evenly on both sides of zero.
+func aroundZero(g Grid) (float64, float64) {
+	hi := percentile(g, 0.98, math.Abs)
+	return -hi, hi
+}
+
+// percentile returns the value of fn that p of the pixels are at or below.
+// It samples the grid so that large maps don't take long.
+func percentile(g Grid, p float64, fn func(float64) float64) float64 {
+	maxx, maxy := len(g), len(g[0])
+	step := 1 + maxx*maxy/100_000
+	var values []float64
+	for n := 0; n < maxx*maxy; n += step {
+		if v := g[n/maxy][n%maxy]; !math.IsNaN(v) {
+			values = append(values, fn(v))
+		}
+	}
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	return values[int(p*float64(len(values)-1))]
+}
+
+// Style holds the settings used to draw a map.
+type Style struct {
+	PctWater int
+	PctIce   int
+	UseHSL   bool
+	Mode     Mode
+	Light    Light
+	// Blend is how much of the shading is applied to the colors
+	// in ModeShaded, from 0 (none) to 1 (all).
+	Blend float64
+	// FalseColor draws layers such as slope in color instead of gray.
+	FalseColor bool
+	// Rivers draws the rivers that drain at least this many pixels
+	// on top of the map. Zero draws no rivers.
+	Rivers int
+	// Lakes draws the water that doesn't reach the ocean in the LakeColors
+	// in ModeColor and ModeShaded.
+	Lakes bool
+	// Flood fills every basin up to its spill point when drawing lakes.
+	Flood bool
+}
+
+// DefaultStyle is the style used for settings that aren't given.
+var DefaultStyle = Style{PctWater: 33, PctIce: 8, Mode: ModeColor, Light: DefaultLight, Blend: 0.7}
+
+// ParseMode returns the mode with the given name.
+func ParseMode(name string) (Mode, error) {
+	for _, mode := range Modes {
+		if string(mode) == name {
+			return mode, nil
+		}
+	}
+	var names []string
+	for _, mode := range Modes {
+		names = append(names, string(mode))
+	}
+	return ModeColor, fmt.Errorf("%q: must be one of %s", name, strings.Join(names, ", "))
+}
+
+// Validate returns an error if the style can't be used.
+func (st Style) Validate() error {
+	if _, err := ParseMode(string(st.Mode)); err != nil {
+		return fmt.Errorf("mode: %w", err)
+	} else if err := st.Light.Validate(); err != nil {
+		return err
+	} else if st.Blend < 0 || st.Blend > 1 {
+		return fmt.Errorf("blend: must be between 0 and 1")
+	} else if st.Rivers < 0 {
+		return fmt.Errorf("rivers: must not be negative")
+	}
+	return nil
+}
+
+// Climate returns the DefaultClimate of the map, with the sea at the water level of the style.
+func (st Style) Climate(hm *Map) (*Climate, error) {
+	opts := DefaultClimate
+	opts.SeaLevel = hm.SeaLevel(st.PctWater)
+	return hm.Climate(opts)
+}
+
+// Draw returns an image of the map, with the rivers on top.
+// It sets the colors of the map.
+func (st Style) Draw(hm *Map) (*image.RGBA, error) {
+	if err := st.Validate(); err != nil {
+		return nil, err
+	}
+	var d *Drainage
+	if st.Rivers > 0 || st.Lakes {
+		d = hm.Drainage(hm.SeaLevel(st.PctWater), st.Lakes && st.Flood)
+	}
+	img, err := st.draw(hm, d)
+	if err != nil {
+		return nil, err
+	}
+	if st.Rivers > 0 {
+		d.drawRivers(img, d.Rivers(float64(st.Rivers)), float64(st.Rivers))
+	}
+	return img, nil
+}
+
+// draw returns an image of the map in the mode of the style.
+// The drainage is only used if the style draws lakes.
+func (st Style) draw(hm *Map, d *Drainage) (*image.RGBA, error) {
+	if st.Mode == ModeHillshade {
+		return hm.Hillshade(st.Light).Image(0, 1, GrayRamp), nil
+	} else if st.Mode == ModeBiome {
+		c, err := st.Climate(hm)
+		if err != nil {
+			return nil, err
+		}
+		return c.Biomes().Image(), nil
+	} else if layer, ok := layers[st.Mode]; ok {
+		g, err := layer.grid(hm, st)
+		if err != nil {
+			return nil, err
+		}
+		lo, hi := layer.span(g)
+		if st.FalseColor {
+			return g.Image(lo, hi, layer.ramp), nil
+		}
+		return g.Image(lo, hi, GrayRamp), nil
+	}
+
+	var err error
+	if st.UseHSL {
+		err = hm.ColorHSL(st.PctWater, st.PctIce, WaterColors, AlternateLandColors, IceColors)
+	} else {
+		err = hm.Color(st.PctWater, 100-st.PctIce, st.PctIce, WaterColors, LandColors, IceColors)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if st.Lakes {
+		hm.colorLakes(d)
+	}
+	if st.Mode == ModeShaded {
+		flat := math.Sin(st.Light.Altitude * math.Pi / 180)
+		return hm.asShadedImage(hm.Hillshade(st.Light), flat, st.Blend), nil
+	}
+	return hm.AsImage()
+}
+
+// Render returns the map drawn as a PNG image.
+// It sets the colors of the map.
+func (st Style) Render(hm *Map) ([]byte, error) {
+	img, err := st.Draw(hm)
+	if err != nil {
+		return nil, err
+	}
+	return encodePNG(img)
+}
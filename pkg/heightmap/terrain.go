@@ -0,0 +1,181 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"math"
+)
+
+// The layers in this file are derived from the elevations around each pixel.
+// Pixels on the edges of the map use the pixels on the other side of the map
+// when the map wraps and repeat the edge pixels when it doesn't.
+
+// Scale relates elevations to distances across the map,
+// so that slopes can be measured in degrees.
+type Scale struct {
+	// Pixel is the distance across a pixel.
+	Pixel float64
+	// Relief is the height from the lowest elevation on the map to the highest,
+	// in the same units as Pixel.
+	Relief float64
+}
+
+// DefaultScale returns the scale used for shading, where the highest point
+// on the map is a fiftieth of the width of the map above the lowest.
+func (hm *Map) DefaultScale() Scale {
+	return Scale{Pixel: 1, Relief: float64(len(hm.Data)) / 50}
+}
+
+// rise returns the factor that converts a change in elevation to a distance in pixels.
+func (sc Scale) rise(hm *Map) float64 {
+	f := sc.Relief / sc.Pixel
+	if hm.MaxZ > hm.MinZ {
+		f /= hm.MaxZ - hm.MinZ
+	}
+	return f
+}
+
+// window returns the elevations of the pixel and its neighbors,
+// converted to pixels, as
+//
+//	z[0] z[1] z[2]    (north)
+//	z[3] z[4] z[5]
+//	z[6] z[7] z[8]    (south)
+type window [9]float64
+
+// neighborhood calls fn with the window around every pixel.
+func (hm *Map) neighborhood(sc Scale, fn func(x, y int, z *window)) {
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	s, f := newSampler(hm, hm.WrapX, hm.WrapY), sc.rise(hm)
+	var z window
+	for x := 0; x < maxx; x++ {
+		for y := 0; y < maxy; y++ {
+			for n := range z {
+				z[n] = s.at(x+n%3-1, y+n/3-1) * f
+			}
+			fn(x, y, &z)
+		}
+	}
+}
+
+// gradient returns the rise per pixel to the east and to the south, using Horn's method.
+func (z *window) gradient() (dzdx, dzdy float64) {
+	dzdx = ((z[2] + 2*z[5] + z[8]) - (z[0] + 2*z[3] + z[6])) / 8
+	dzdy = ((z[6] + 2*z[7] + z[8]) - (z[0] + 2*z[1] + z[2])) / 8
+	return dzdx, dzdy
+}
+
+// Slope returns the steepness of the terrain at every pixel, in degrees from 0 (flat) to 90.
+func (hm *Map) Slope(sc Scale) Grid {
+	g := newGrid(len(hm.Data), len(hm.Data[0]))
+	hm.neighborhood(sc, func(x, y int, z *window) {
+		dzdx, dzdy := z.gradient()
+		g[x][y] = math.Atan(math.Hypot(dzdx, dzdy)) * 180 / math.Pi
+	})
+	return g
+}
+
+// Aspect returns the direction that the terrain faces at every pixel,
+// in degrees clockwise from north (the top of the map), from 0 up to 360.
+// Flat pixels don't face any direction and are NaN.
+func (hm *Map) Aspect() Grid {
+	g := newGrid(len(hm.Data), len(hm.Data[0]))
+	hm.neighborhood(hm.DefaultScale(), func(x, y int, z *window) {
+		dzdx, dzdy := z.gradient()
+		if dzdx == 0 && dzdy == 0 {
+			g[x][y] = math.NaN()
+			return
+		}
+		// downhill is against the gradient
+		aspect := math.Atan2(-dzdx, dzdy) * 180 / math.Pi
+		if aspect < 0 {
+			aspect += 360
+		}
+		g[x][y] = aspect
+	})
+	return g
+}
+
+// curvature returns the profile and plan curvature at the center of the window,
+// using the method of Zevenbergen and Thorne.
+func (z *window) curvature() (profile, plan float64) {
+	d := ((z[3]+z[5])/2 - z[4])
+	e := ((z[1]+z[7])/2 - z[4])
+	f := (-z[0] + z[2] + z[6] - z[8]) / 4
+	g := (z[5] - z[3]) / 2
+	h := (z[1] - z[7]) / 2
+	if g == 0 && h == 0 {
+		return 0, 0
+	}
+	profile = -2 * (d*g*g + e*h*h + f*g*h) / (g*g + h*h)
+	plan = -2 * (d*h*h + e*g*g - f*g*h) / (g*g + h*h)
+	return profile, plan
+}
+
+// ProfileCurvature returns the curvature of the terrain in the direction of
+// the slope at every pixel, per pixel.
+// It is positive where the slope gets steeper going downhill (convex),
+// and negative where it levels out (concave).
+func (hm *Map) ProfileCurvature(sc Scale) Grid {
+	g := newGrid(len(hm.Data), len(hm.Data[0]))
+	hm.neighborhood(sc, func(x, y int, z *window) {
+		g[x][y], _ = z.curvature()
+	})
+	return g
+}
+
+// PlanCurvature returns the curvature of the terrain across the slope at
+// every pixel, per pixel.
+// It is positive on ridges, where water spreads out (convex),
+// and negative in valleys, where water is gathered (concave).
+func (hm *Map) PlanCurvature(sc Scale) Grid {
+	g := newGrid(len(hm.Data), len(hm.Data[0]))
+	hm.neighborhood(sc, func(x, y int, z *window) {
+		_, g[x][y] = z.curvature()
+	})
+	return g
+}
+
+// Roughness returns the difference between the highest and lowest elevations
+// around every pixel, in the units of the scale.
+func (hm *Map) Roughness(sc Scale) Grid {
+	g := newGrid(len(hm.Data), len(hm.Data[0]))
+	hm.neighborhood(sc, func(x, y int, z *window) {
+		lo, hi := z[0], z[0]
+		for _, v := range z {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+		g[x][y] = (hi - lo) * sc.Pixel
+	})
+	return g
+}
+
+// TRI returns the terrain ruggedness index of every pixel: the mean difference
+// in elevation between the pixel and its eight neighbors, in the units of the scale.
+func (hm *Map) TRI(sc Scale) Grid {
+	g := newGrid(len(hm.Data), len(hm.Data[0]))
+	hm.neighborhood(sc, func(x, y int, z *window) {
+		var sum float64
+		for n, v := range z {
+			if n != 4 {
+				sum += math.Abs(v - z[4])
+			}
+		}
+		g[x][y] = sum / 8 * sc.Pixel
+	})
+	return g
+}
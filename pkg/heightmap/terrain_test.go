@@ -0,0 +1,91 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"math"
+	"testing"
+)
+
+// ramp returns a 5x5 map that rises by a quarter of its relief for every
+// pixel east (dx) and south (dy), offset so that it stays between 0 and 1.
+func ramp(dx, dy float64) *Map {
+	hm := &Map{MaxZ: 1, Data: newGrid(5, 5)}
+	for x := range hm.Data {
+		for y := range hm.Data[x] {
+			hm.Data[x][y] = 0.5 + (dx*float64(x-2)+dy*float64(y-2))/8
+		}
+	}
+	return hm
+}
+
+func TestSlopeAndAspect(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		hm     *Map
+		relief float64
+		slope  float64
+		aspect float64 // NaN for flat ground
+	}{
+		{"flat", ramp(0, 0), 4, 0, math.NaN()},
+		{"rises to the east", ramp(1, 0), 8, 45, 270},
+		{"rises to the west", ramp(-1, 0), 8, 45, 90},
+		{"rises to the south", ramp(0, 1), 8, 45, 0},
+		{"rises to the north", ramp(0, -1), 8, 45, 180},
+		{"rises to the southeast", ramp(1, 1), 8, math.Atan(math.Sqrt2) * 180 / math.Pi, 315},
+		{"steeper", ramp(1, 0), 8 * math.Sqrt(3), 60, 270},
+		{"gentler", ramp(1, 0), 8 / math.Sqrt(3), 30, 270},
+	} {
+		// the relief is measured from MinZ to MaxZ, which is 8 pixels of the ramp
+		sc := Scale{Pixel: 1, Relief: tc.relief}
+		slope, aspect := tc.hm.Slope(sc), tc.hm.Aspect()
+		profile, plan := tc.hm.ProfileCurvature(sc), tc.hm.PlanCurvature(sc)
+		// the edges repeat the pixels next to them, so only the middle is measured
+		for x := 1; x < 4; x++ {
+			for y := 1; y < 4; y++ {
+				if math.Abs(slope[x][y]-tc.slope) > 1e-9 {
+					t.Errorf("%s: %d %d: got slope %g, want %g", tc.name, x, y, slope[x][y], tc.slope)
+				}
+				if math.IsNaN(tc.aspect) != math.IsNaN(aspect[x][y]) || math.Abs(aspect[x][y]-tc.aspect) > 1e-9 {
+					t.Errorf("%s: %d %d: got aspect %g, want %g", tc.name, x, y, aspect[x][y], tc.aspect)
+				}
+				if math.Abs(profile[x][y]) > 1e-9 || math.Abs(plan[x][y]) > 1e-9 {
+					t.Errorf("%s: %d %d: got curvature %g %g, want 0", tc.name, x, y, profile[x][y], plan[x][y])
+				}
+			}
+		}
+	}
+}
+
+func TestSlopeWraps(t *testing.T) {
+	// a ridge along the left edge: on a map that wraps, it is the top of the
+	// ridge and flat; otherwise the edge repeats and it slopes down to the east
+	hm := &Map{MaxZ: 1, Data: [][]float64{{1, 1, 1}, {0.5, 0.5, 0.5}, {0, 0, 0}, {0.5, 0.5, 0.5}}}
+	sc := Scale{Pixel: 1, Relief: 2}
+	for _, tc := range []struct {
+		wrapX bool
+		want  float64
+	}{
+		{true, 0},
+		{false, math.Atan(0.5) * 180 / math.Pi},
+	} {
+		hm.WrapX = tc.wrapX
+		if got := hm.Slope(sc)[0][1]; math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("wrap %v: got %g, want %g", tc.wrapX, got, tc.want)
+		}
+	}
+}
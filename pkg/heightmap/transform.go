@@ -0,0 +1,315 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Transform is a single step in a Pipeline.
+type Transform struct {
+	Op   string    `json:"op"`
+	Args []float64 `json:"args,omitempty"`
+	// Method is the interpolation used by transforms that resample the map.
+	// The default is nearest.
+	Method string `json:"method,omitempty"`
+}
+
+// Pipeline is a list of transforms that are applied in order.
+// Applying a pipeline never changes the original map.
+//
+// A pipeline can be written as a URL-safe string: transforms are separated by
+// "~" and the name of each transform is followed by its arguments, separated by "_".
+// Transforms that resample the map may add the interpolation to the name after a ".".
+// For example, "rotate_90~shift.bicubic_12.5_0~sea-level_0.3".
+type Pipeline []Transform
+
+// transformOp describes a transform.
+type transformOp struct {
+	// minArgs and maxArgs are the number of arguments allowed
+	minArgs, maxArgs int
+	usage            string
+	// interpolates is set if the transform accepts an interpolation method
+	interpolates bool
+	// apply returns a new map; it must not change hm
+	apply func(hm *Map, args []float64, method Interpolation) (*Map, error)
+}
+
+var transformOps = map[string]transformOp{
+	"crop": {4, 4, "crop_x_y_width_height keeps a rectangle of the map (in pixels)", false, applyCrop},
+	"flip-h": {0, 0, "flip-h mirrors the map left to right", false, func(hm *Map, _ []float64, _ Interpolation) (*Map, error) {
+		return hm.FlipHorizontal(), nil
+	}},
+	"flip-v": {0, 0, "flip-v mirrors the map top to bottom", false, func(hm *Map, _ []float64, _ Interpolation) (*Map, error) {
+		return hm.FlipVertical(), nil
+	}},
+	"invert":    {0, 0, "invert swaps high and low elevations", false, applyInvert},
+	"pad":       {1, 2, "pad_n or pad_n_z adds n pixels to each side at elevation z (0 to 1, default 0)", false, applyPad},
+	"resize":    {2, 2, "resize.method_width_height resizes the map to the given number of pixels", true, applyResize},
+	"rotate":    {1, 1, "rotate_degrees rotates the map clockwise by a multiple of 90 degrees", false, applyRotate},
+	"scale":     {1, 2, "scale.method_factor or scale.method_x_y resizes the map by a factor", true, applyScale},
+	"sea-level": {1, 1, "sea-level_z raises the sea floor to elevation z (0 to 1)", false, applySeaLevel},
+	"shift":     {2, 3, "shift.method_x_y or shift.method_x_y_wrap shifts the map right and down by a percentage of its size; wrap 1 wraps the top and bottom", true, applyShift},
+	"shift-px":  {2, 3, "shift-px.method_x_y or shift-px.method_x_y_wrap shifts the map right and down by a number of pixels; wrap 1 wraps the top and bottom", true, applyShiftPixels},
+}
+
+// TransformUsage returns a line describing each transform, sorted by name.
+func TransformUsage() []string {
+	var lines []string
+	for _, op := range transformOps {
+		lines = append(lines, op.usage)
+	}
+	sort.Strings(lines)
+	return append(lines, "method is one of "+strings.Join(interpolationNames, ", ")+" and may be omitted")
+}
+
+// ParsePipeline parses a pipeline from its string form.
+// The empty string is an empty pipeline.
+func ParsePipeline(s string) (Pipeline, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var p Pipeline
+	for _, step := range strings.Split(s, "~") {
+		fields := strings.Split(step, "_")
+		var t Transform
+		if name, method, ok := strings.Cut(fields[0], "."); ok {
+			t.Op, t.Method = name, method
+		} else {
+			t.Op = name
+		}
+		for _, field := range fields[1:] {
+			arg, err := strconv.ParseFloat(field, 64)
+			if err != nil || math.IsNaN(arg) || math.IsInf(arg, 0) {
+				return nil, fmt.Errorf("%s: %q: invalid argument", t.Op, field)
+			}
+			t.Args = append(t.Args, arg)
+		}
+		p = append(p, t)
+	}
+	return p, p.Validate()
+}
+
+// String returns the URL-safe form of the pipeline.
+func (p Pipeline) String() string {
+	var steps []string
+	for _, t := range p {
+		step := t.Op
+		if t.Method != "" {
+			step += "." + t.Method
+		}
+		for _, arg := range t.Args {
+			step += "_" + strconv.FormatFloat(arg, 'f', -1, 64)
+		}
+		steps = append(steps, step)
+	}
+	return strings.Join(steps, "~")
+}
+
+// Validate checks that every transform is known and has the right number of arguments.
+// It does not check the values of the arguments, since some depend on the map.
+func (p Pipeline) Validate() error {
+	for _, t := range p {
+		op, ok := transformOps[t.Op]
+		if !ok {
+			return fmt.Errorf("%q: unknown transform", t.Op)
+		} else if len(t.Args) < op.minArgs || len(t.Args) > op.maxArgs {
+			return fmt.Errorf("%s: usage: %s", t.Op, op.usage)
+		} else if t.Method != "" {
+			if !op.interpolates {
+				return fmt.Errorf("%s: does not accept an interpolation method", t.Op)
+			} else if _, err := ParseInterpolation(t.Method); err != nil {
+				return fmt.Errorf("%s: %w", t.Op, err)
+			}
+		}
+	}
+	return nil
+}
+
+// DefaultMaxWidth and DefaultMaxHeight are the largest map, in pixels, that Apply makes.
+const DefaultMaxWidth, DefaultMaxHeight = 4 * 1024, 4 * 1024
+
+// Apply returns a new map with every transform applied.
+// The original map is not changed.
+// The new map does not have colors; call Color or ColorHSL on it.
+// No step may grow a map past DefaultMaxWidth by DefaultMaxHeight.
+func (p Pipeline) Apply(hm *Map) (*Map, error) {
+	return p.ApplyWithin(hm, DefaultMaxWidth, DefaultMaxHeight)
+}
+
+// ApplyWithin is Apply with a limit on the size of the maps made by each step.
+// The pipeline is rejected before any work is done if a step would grow
+// a map wider than maxWidth or taller than maxHeight.
+// Maps that are already larger can still be transformed if they don't grow.
+func (p Pipeline) ApplyWithin(hm *Map, maxWidth, maxHeight int) (*Map, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	width, height := float64(len(hm.Data)), float64(len(hm.Data[0]))
+	for _, t := range p {
+		w, h := t.size(width, height)
+		if (w > width && w > float64(maxWidth)) || (h > height && h > float64(maxHeight)) {
+			return nil, fmt.Errorf("%s: %gx%g: larger than the %dx%d limit", t.Op, w, h, maxWidth, maxHeight)
+		}
+		width, height = w, h
+	}
+	if len(p) == 0 {
+		return hm.Copy(), nil
+	}
+	for _, t := range p {
+		method := Nearest
+		if t.Method != "" {
+			method, _ = ParseInterpolation(t.Method)
+		}
+		var err error
+		if hm, err = transformOps[t.Op].apply(hm, t.Args, method); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Op, err)
+		}
+	}
+	return hm, nil
+}
+
+// size returns the size of the map that the transform makes from a map
+// of the given size. The sizes are floats so that huge arguments don't overflow.
+func (t Transform) size(width, height float64) (float64, float64) {
+	switch t.Op {
+	case "crop":
+		return math.Trunc(t.Args[2]), math.Trunc(t.Args[3])
+	case "pad":
+		n := math.Trunc(t.Args[0])
+		return width + 2*n, height + 2*n
+	case "resize":
+		return math.Round(t.Args[0]), math.Round(t.Args[1])
+	case "rotate":
+		if math.Mod(t.Args[0], 180) != 0 {
+			return height, width
+		}
+	case "scale":
+		sx, sy := t.Args[0], t.Args[0]
+		if len(t.Args) == 2 {
+			sy = t.Args[1]
+		}
+		return math.Round(width * sx), math.Round(height * sy)
+	}
+	return width, height
+}
+
+func applyCrop(hm *Map, args []float64, _ Interpolation) (*Map, error) {
+	return hm.Crop(int(args[0]), int(args[1]), int(args[2]), int(args[3]))
+}
+
+func applyInvert(hm *Map, _ []float64, _ Interpolation) (*Map, error) {
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	nm := newMap(hm, maxx, maxy)
+	for x := 0; x < maxx; x++ {
+		for y := 0; y < maxy; y++ {
+			nm.Data[x][y] = hm.MaxZ + hm.MinZ - hm.Data[x][y]
+		}
+	}
+	return nm, nil
+}
+
+func applyPad(hm *Map, args []float64, _ Interpolation) (*Map, error) {
+	n := int(args[0])
+	fill := hm.MinZ
+	if len(args) == 2 {
+		fill = hm.MinZ + args[1]*(hm.MaxZ-hm.MinZ)
+	}
+	return hm.Pad(n, n, n, n, fill)
+}
+
+func applyRotate(hm *Map, args []float64, _ Interpolation) (*Map, error) {
+	if args[0] != float64(int(args[0])) {
+		return nil, fmt.Errorf("%g: must be a multiple of 90 degrees", args[0])
+	}
+	return hm.RotateBy(int(args[0]))
+}
+
+// applyResize resizes the map to exactly the given width and height,
+// using the given interpolation.
+func applyResize(hm *Map, args []float64, method Interpolation) (*Map, error) {
+	return resize(hm, int(math.Round(args[0])), int(math.Round(args[1])), method)
+}
+
+// applyScale resizes the map by a factor, or by a factor for each axis,
+// using the given interpolation.
+func applyScale(hm *Map, args []float64, method Interpolation) (*Map, error) {
+	sx, sy := args[0], args[0]
+	if len(args) == 2 {
+		sy = args[1]
+	}
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	return resize(hm, int(math.Round(float64(maxx)*sx)), int(math.Round(float64(maxy)*sy)), method)
+}
+
+// resize rejects empty maps.
+// The largest size is checked by ApplyWithin before any transform runs.
+func resize(hm *Map, width, height int, method Interpolation) (*Map, error) {
+	if width < 1 || height < 1 {
+		return nil, fmt.Errorf("%dx%d: invalid size", width, height)
+	}
+	return hm.Resize(width, height, method)
+}
+
+func applySeaLevel(hm *Map, args []float64, _ Interpolation) (*Map, error) {
+	level := args[0]
+	if level < 0 || level > 1 {
+		return nil, fmt.Errorf("%g: must be between 0 and 1", level)
+	}
+	z := hm.MinZ + level*(hm.MaxZ-hm.MinZ)
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	nm := newMap(hm, maxx, maxy)
+	for x := 0; x < maxx; x++ {
+		for y := 0; y < maxy; y++ {
+			nm.Data[x][y] = math.Max(hm.Data[x][y], z)
+		}
+	}
+	return nm, nil
+}
+
+func applyShift(hm *Map, args []float64, method Interpolation) (*Map, error) {
+	wrapY, err := shiftWrapY(hm, args)
+	if err != nil {
+		return nil, err
+	}
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	return hm.Shift(args[0]*float64(maxx)/100, args[1]*float64(maxy)/100, wrapY, method), nil
+}
+
+func applyShiftPixels(hm *Map, args []float64, method Interpolation) (*Map, error) {
+	wrapY, err := shiftWrapY(hm, args)
+	if err != nil {
+		return nil, err
+	}
+	return hm.Shift(args[0], args[1], wrapY, method), nil
+}
+
+// shiftWrapY returns the optional third argument of a shift, which is 1 to wrap
+// the top and bottom of the map and 0 to stretch them.
+// Without it, the map wraps top to bottom if WrapY is set.
+func shiftWrapY(hm *Map, args []float64) (bool, error) {
+	if len(args) < 3 {
+		return hm.WrapY, nil
+	} else if args[2] != 0 && args[2] != 1 {
+		return false, fmt.Errorf("%g: wrap must be 0 or 1", args[2])
+	}
+	return args[2] == 1, nil
+}
@@ -0,0 +1,114 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package heightmap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePipeline(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  Pipeline // nil if the input is rejected, unless it is empty
+	}{
+		{"", nil},
+		{"invert", Pipeline{{Op: "invert"}}},
+		{"rotate_90", Pipeline{{Op: "rotate", Args: []float64{90}}}},
+		{"rotate_-90~flip-h", Pipeline{{Op: "rotate", Args: []float64{-90}}, {Op: "flip-h"}}},
+		{"shift.bicubic_12.5_0", Pipeline{{Op: "shift", Args: []float64{12.5, 0}, Method: "bicubic"}}},
+		{"shift-px_3_4_1", Pipeline{{Op: "shift-px", Args: []float64{3, 4, 1}}}},
+		{"pad_2~sea-level_0.3", Pipeline{{Op: "pad", Args: []float64{2}}, {Op: "sea-level", Args: []float64{0.3}}}},
+		{"resize.lanczos_320_160", Pipeline{{Op: "resize", Args: []float64{320, 160}, Method: "lanczos"}}},
+		{"crop_1_2_3_4", Pipeline{{Op: "crop", Args: []float64{1, 2, 3, 4}}}},
+		{"scale_0.5", Pipeline{{Op: "scale", Args: []float64{0.5}}}},
+		{"spin_90", nil},
+		{"rotate", nil},
+		{"rotate_90_1", nil},
+		{"rotate_ninety", nil},
+		{"rotate_NaN", nil},
+		{"rotate_Inf", nil},
+		{"crop_1_2_3", nil},
+		{"invert_1", nil},
+		{"rotate.bicubic_90", nil},
+		{"shift.cubist_1_1", nil},
+		{"invert~", nil},
+		{"~invert", nil},
+	} {
+		got, err := ParsePipeline(tc.input)
+		if tc.want == nil && tc.input != "" {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.input)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q: unexpected error %v", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %+v, want %+v", tc.input, got, tc.want)
+		}
+		if s := got.String(); s != tc.input {
+			t.Errorf("%q: string: got %q", tc.input, s)
+		}
+	}
+}
+
+func TestApplyWithin(t *testing.T) {
+	for _, tc := range []struct {
+		pipeline            string
+		maxWidth, maxHeight int
+		width, height       int // 0 if the pipeline is rejected
+	}{
+		{"", 3, 2, 3, 2},
+		{"rotate_90", 3, 3, 2, 3},
+		{"pad_1", 5, 4, 5, 4},
+		{"pad_1", 4, 4, 0, 0},
+		{"pad_1", 5, 3, 0, 0},
+		{"pad_1e300", 4096, 4096, 0, 0},
+		{"scale_2", 6, 4, 6, 4},
+		{"scale_2", 5, 4, 0, 0},
+		{"resize_10_1", 9, 2, 0, 0},
+		{"pad_1~crop_0_0_2_2", 5, 4, 2, 2},
+		{"crop_0_0_2_2~pad_1", 3, 3, 0, 0},
+		// maps that are already too large may be transformed if they don't grow
+		{"rotate_180", 1, 1, 3, 2},
+		{"crop_0_0_2_1", 1, 1, 2, 1},
+	} {
+		p, err := ParsePipeline(tc.pipeline)
+		if err != nil {
+			t.Fatalf("%q: unexpected error %v", tc.pipeline, err)
+		}
+		hm := testMap()
+		nm, err := p.ApplyWithin(hm, tc.maxWidth, tc.maxHeight)
+		if tc.width == 0 {
+			if err == nil {
+				t.Errorf("%q within %dx%d: expected an error", tc.pipeline, tc.maxWidth, tc.maxHeight)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q within %dx%d: unexpected error %v", tc.pipeline, tc.maxWidth, tc.maxHeight, err)
+			continue
+		}
+		if len(nm.Data) != tc.width || len(nm.Data[0]) != tc.height {
+			t.Errorf("%q within %dx%d: got %dx%d, want %dx%d", tc.pipeline, tc.maxWidth, tc.maxHeight, len(nm.Data), len(nm.Data[0]), tc.width, tc.height)
+		}
+		if nm == hm || !reflect.DeepEqual(rows(hm), rows(testMap())) {
+			t.Errorf("%q: changed the original map", tc.pipeline)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package jobs implements a bounded pool of workers for long-running tasks.
+//
+// Every job has a key. Submitting a job with the same key as a job that
+// is queued or running returns the existing job instead of starting a new one.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Submit when there is no room for another job.
+var ErrQueueFull = errors.New("job queue is full")
+
+// Status is the state of a job.
+type Status string
+
+const (
+	Queued   Status = "queued"
+	Running  Status = "running"
+	Done     Status = "done"
+	Failed   Status = "failed"
+	Canceled Status = "canceled"
+)
+
+// Func is the work done by a job.
+// It should return promptly with ctx.Err() when the context is canceled.
+// It may call progress to report that done out of total steps are complete.
+// The result is saved with the job when it completes without error.
+type Func func(ctx context.Context, progress func(done, total int)) (result string, err error)
+
+// Job is a unit of work.
+type Job struct {
+	id     string
+	key    string
+	fn     Func
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	sync.Mutex
+	status   Status
+	steps    int // number of steps completed
+	total    int
+	result   string
+	err      error
+	created  time.Time
+	started  time.Time
+	finished time.Time
+}
+
+// Snapshot is a copy of the state of a job.
+type Snapshot struct {
+	ID       string     `json:"id"`
+	Status   Status     `json:"status"`
+	Steps    int        `json:"steps"`
+	Total    int        `json:"total"`
+	Result   string     `json:"result,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// ID returns the unique id of the job.
+func (j *Job) ID() string {
+	return j.id
+}
+
+// Done returns a channel that is closed when the job finishes.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Cancel asks the job to stop.
+// A queued job is not started; a running job stops when its function
+// notices that its context has been canceled.
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Snapshot returns the current state of the job.
+func (j *Job) Snapshot() Snapshot {
+	j.Lock()
+	defer j.Unlock()
+	s := Snapshot{
+		ID:      j.id,
+		Status:  j.status,
+		Steps:   j.steps,
+		Total:   j.total,
+		Result:  j.result,
+		Created: j.created,
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		s.Started = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		s.Finished = &finished
+	}
+	if j.err != nil {
+		s.Error = j.err.Error()
+	}
+	return s
+}
+
+// Queue runs jobs with a fixed number of workers.
+type Queue struct {
+	pending chan *Job
+	// retain is how long finished jobs are kept for status requests
+	retain time.Duration
+
+	sync.Mutex
+	jobs     map[string]*Job // all jobs, by id
+	inflight map[string]*Job // queued or running jobs, by key
+}
+
+// New returns a queue with the given number of workers.
+// At most depth jobs can wait for a worker.
+func New(workers, depth int) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	if depth < 0 {
+		depth = 0
+	}
+	q := &Queue{
+		pending:  make(chan *Job, depth),
+		retain:   time.Hour,
+		jobs:     make(map[string]*Job),
+		inflight: make(map[string]*Job),
+	}
+	for n := 0; n < workers; n++ {
+		go q.worker()
+	}
+	return q
+}
+
+// Submit adds a job to the queue.
+// If a job with the same key is queued or running, that job is
+// returned instead and joined is set.
+func (q *Queue) Submit(key string, fn Func) (job *Job, joined bool, err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if job, ok := q.inflight[key]; ok {
+		return job, true, nil
+	}
+	q.prune()
+
+	job = &Job{
+		id:      newID(),
+		key:     key,
+		fn:      fn,
+		done:    make(chan struct{}),
+		status:  Queued,
+		created: time.Now().UTC(),
+	}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	select {
+	case q.pending <- job:
+	default:
+		job.cancel()
+		return nil, false, ErrQueueFull
+	}
+	q.jobs[job.id] = job
+	q.inflight[key] = job
+	return job, false, nil
+}
+
+// Cancel asks the job with the given id to stop.
+// Later requests with the same key start a new job instead of joining
+// the canceled one.
+func (q *Queue) Cancel(id string) bool {
+	q.Lock()
+	defer q.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return false
+	}
+	job.Cancel()
+	if q.inflight[job.key] == job {
+		delete(q.inflight, job.key)
+	}
+	return true
+}
+
+// Get returns the job with the given id.
+func (q *Queue) Get(id string) (*Job, bool) {
+	q.Lock()
+	defer q.Unlock()
+	job, ok := q.jobs[id]
+	return job, ok
+}
+
+// progress records the number of steps completed.
+func (j *Job) progress(done, total int) {
+	j.Lock()
+	j.steps, j.total = done, total
+	j.Unlock()
+}
+
+func (q *Queue) worker() {
+	for job := range q.pending {
+		var result string
+		var err error
+		if err = job.ctx.Err(); err == nil {
+			job.Lock()
+			job.status, job.started = Running, time.Now().UTC()
+			job.Unlock()
+
+			result, err = job.fn(job.ctx, job.progress)
+		}
+		job.cancel()
+
+		job.Lock()
+		job.finished = time.Now().UTC()
+		if errors.Is(err, context.Canceled) {
+			job.status, job.err = Canceled, err
+		} else if err != nil {
+			job.status, job.err = Failed, err
+		} else {
+			job.status, job.result = Done, result
+		}
+		job.Unlock()
+
+		q.Lock()
+		if q.inflight[job.key] == job {
+			delete(q.inflight, job.key)
+		}
+		q.Unlock()
+
+		close(job.done)
+	}
+}
+
+// prune forgets finished jobs that are older than the retention period.
+// The caller must hold the lock on the queue.
+func (q *Queue) prune() {
+	cutoff := time.Now().Add(-q.retain)
+	for id, job := range q.jobs {
+		job.Lock()
+		expired := !job.finished.IsZero() && job.finished.Before(cutoff)
+		job.Unlock()
+		if expired {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func newID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
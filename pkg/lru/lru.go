@@ -0,0 +1,147 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package lru implements a cache that is bounded by the total size of its
+// values and evicts the least recently used values first.
+package lru
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache is safe for concurrent use.
+type Cache[K comparable, V any] struct {
+	sync.Mutex
+	maxSize int64
+	size    int64
+	sizeOf  func(V) int64
+	ll      *list.List // front is most recently used
+	items   map[K]*list.Element
+	stats   Stats
+}
+
+// Stats reports how well the cache is working.
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"` // values dropped to make room for others
+	Entries   int   `json:"entries"`
+	Size      int64 `json:"size"`
+	MaxSize   int64 `json:"maxSize"`
+}
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+	size  int64
+}
+
+// New returns a cache that holds values up to a total size of maxSize.
+// The size of each value is computed by sizeOf.
+// A value larger than maxSize is never cached.
+func New[K comparable, V any](maxSize int64, sizeOf func(V) int64) *Cache[K, V] {
+	return &Cache[K, V]{
+		maxSize: maxSize,
+		sizeOf:  sizeOf,
+		ll:      list.New(),
+		items:   make(map[K]*list.Element),
+	}
+}
+
+// Get returns the value for the key and marks it as recently used.
+func (c *Cache[K, V]) Get(key K) (value V, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return value, false
+	}
+	c.stats.Hits++
+	c.ll.MoveToFront(el)
+	return el.Value.(*entry[K, V]).value, true
+}
+
+// Add adds or replaces the value for the key,
+// evicting the least recently used values to make room for it.
+func (c *Cache[K, V]) Add(key K, value V) {
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	size := c.sizeOf(value)
+	if size > c.maxSize {
+		return
+	}
+	for c.size+size > c.maxSize {
+		c.remove(c.ll.Back())
+		c.stats.Evictions++
+	}
+	c.items[key] = c.ll.PushFront(&entry[K, V]{key: key, value: value, size: size})
+	c.size += size
+}
+
+// Remove removes the value for the key.
+func (c *Cache[K, V]) Remove(key K) {
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// RemoveFunc removes every value whose key matches.
+func (c *Cache[K, V]) RemoveFunc(match func(key K) bool) {
+	c.Lock()
+	defer c.Unlock()
+	for key, el := range c.items {
+		if match(key) {
+			c.remove(el)
+		}
+	}
+}
+
+// Len returns the number of values in the cache.
+func (c *Cache[K, V]) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.ll.Len()
+}
+
+// Size returns the total size of the values in the cache.
+func (c *Cache[K, V]) Size() int64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.size
+}
+
+// Stats returns the statistics for the cache.
+func (c *Cache[K, V]) Stats() Stats {
+	c.Lock()
+	defer c.Unlock()
+	stats := c.stats
+	stats.Entries, stats.Size, stats.MaxSize = c.ll.Len(), c.size, c.maxSize
+	return stats
+}
+
+// remove drops an element. The caller must hold the lock.
+func (c *Cache[K, V]) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*entry[K, V])
+	delete(c.items, e.key)
+	c.size -= e.size
+}
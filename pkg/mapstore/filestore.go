@@ -0,0 +1,184 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mapstore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// legacyExtension is the extension for maps saved as JSON by older versions.
+const legacyExtension = ".json"
+
+// FileStore keeps maps as files in a single directory.
+// New maps are written in the binary format; legacy JSON maps can be read.
+type FileStore struct {
+	root string
+	opts heightmap.EncodeOptions
+}
+
+// NewFileStore returns a store rooted at the given directory.
+// The directory must exist.
+func NewFileStore(root string, opts heightmap.EncodeOptions) (*FileStore, error) {
+	if sb, err := os.Stat(root); err != nil {
+		return nil, err
+	} else if !sb.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", root)
+	}
+	return &FileStore{root: filepath.Clean(root), opts: opts}, nil
+}
+
+// files returns the names of the files that could hold the map.
+// The binary format is preferred over the legacy JSON format.
+func (st *FileStore) files(id string) []string {
+	return []string{
+		filepath.Join(st.root, id+heightmap.Extension),
+		filepath.Join(st.root, id+legacyExtension),
+	}
+}
+
+func (st *FileStore) Put(id string, hm *heightmap.Map) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	names := st.files(id)
+
+	// write to a temporary file and rename it so that readers never see a partial map
+	fp, err := os.CreateTemp(st.root, ".tmp-"+id+"-*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fp)
+	if err = hm.Encode(w, st.opts); err == nil {
+		err = w.Flush()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(fp.Name(), names[0])
+	}
+	if err != nil {
+		_ = os.Remove(fp.Name())
+		return err
+	}
+
+	// remove any legacy file so that it can't shadow the new map
+	for _, name := range names[1:] {
+		_ = os.Remove(name)
+	}
+	return nil
+}
+
+func (st *FileStore) Get(id string) (*heightmap.Map, error) {
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+	for _, name := range st.files(id) {
+		fp, err := os.Open(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		hm, err := heightmap.Decode(bufio.NewReader(fp))
+		_ = fp.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return hm, nil
+	}
+	return nil, ErrNotExist
+}
+
+func (st *FileStore) Stat(id string) (Info, error) {
+	if err := checkID(id); err != nil {
+		return Info{}, err
+	}
+	for _, name := range st.files(id) {
+		sb, err := os.Stat(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return Info{}, err
+		}
+		info := Info{ID: id, Size: sb.Size(), ModTime: sb.ModTime()}
+		// legacy maps don't record metadata, so don't waste time parsing them
+		if strings.HasSuffix(name, heightmap.Extension) {
+			fp, err := os.Open(name)
+			if err != nil {
+				return Info{}, err
+			}
+			info.Metadata, err = heightmap.DecodeMetadata(fp)
+			_ = fp.Close()
+			if err != nil {
+				return Info{}, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		return info, nil
+	}
+	return Info{}, ErrNotExist
+}
+
+func (st *FileStore) List() ([]Info, error) {
+	entries, err := os.ReadDir(st.root)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var list []Info
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		name := entry.Name()
+		for _, ext := range []string{heightmap.Extension, legacyExtension} {
+			id := strings.TrimSuffix(name, ext)
+			if id == name || seen[id] || !ValidID(id) {
+				continue
+			}
+			seen[id] = true
+			info, err := st.Stat(id)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, info)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (st *FileStore) Delete(id string) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	for _, name := range st.files(id) {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
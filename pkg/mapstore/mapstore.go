@@ -0,0 +1,75 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package mapstore implements storage for generated maps.
+package mapstore
+
+import (
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"io/fs"
+	"time"
+)
+
+// ErrNotExist is returned when a map is not in the store.
+// It is the same as fs.ErrNotExist so that errors.Is works with either.
+var ErrNotExist = fs.ErrNotExist
+
+// MapStore is the interface for storing maps.
+// Maps are identified by an id, which must pass ValidID.
+type MapStore interface {
+	// Put saves the map, replacing any existing map with the same id.
+	Put(id string, hm *heightmap.Map) error
+	// Get loads the map.
+	Get(id string) (*heightmap.Map, error)
+	// Stat returns information about the map without loading the elevations.
+	Stat(id string) (Info, error)
+	// List returns information about all the maps, sorted by id.
+	List() ([]Info, error)
+	// Delete removes the map. It is not an error to delete a map that doesn't exist.
+	Delete(id string) error
+}
+
+// Info describes a stored map.
+type Info struct {
+	ID      string
+	Size    int64
+	ModTime time.Time
+	// Metadata is the provenance of the map.
+	// It is nil for legacy maps that didn't record it.
+	Metadata *heightmap.Metadata
+}
+
+// ValidID returns true if the id is safe to use as a file name.
+// Map ids are the seed, optionally followed by a dash and the generator name.
+func ValidID(id string) bool {
+	if id == "" || !('0' <= id[0] && id[0] <= '9' || id[0] == '-') {
+		return false
+	}
+	for _, ch := range id {
+		if !('0' <= ch && ch <= '9' || 'a' <= ch && ch <= 'z' || ch == '-' || ch == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func checkID(id string) error {
+	if !ValidID(id) {
+		return fmt.Errorf("%q: invalid map id", id)
+	}
+	return nil
+}
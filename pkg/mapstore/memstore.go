@@ -0,0 +1,111 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mapstore
+
+import (
+	"bytes"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps maps in memory.
+// It is intended for tests.
+// Maps are stored encoded, so callers never share data with the store.
+type MemoryStore struct {
+	sync.Mutex
+	maps map[string]memoryMap
+}
+
+type memoryMap struct {
+	data    []byte
+	modTime time.Time
+	meta    *heightmap.Metadata
+}
+
+// NewMemoryStore returns an empty store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{maps: make(map[string]memoryMap)}
+}
+
+func (ms *MemoryStore) Put(id string, hm *heightmap.Map) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	bb := &bytes.Buffer{}
+	if err := hm.Encode(bb, heightmap.EncodeOptions{DataType: heightmap.Float64}); err != nil {
+		return err
+	}
+	ms.Lock()
+	defer ms.Unlock()
+	ms.maps[id] = memoryMap{data: bb.Bytes(), modTime: time.Now(), meta: hm.Metadata.Copy()}
+	return nil
+}
+
+func (ms *MemoryStore) Get(id string) (*heightmap.Map, error) {
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+	ms.Lock()
+	m, ok := ms.maps[id]
+	ms.Unlock()
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return heightmap.Decode(bytes.NewReader(m.data))
+}
+
+func (ms *MemoryStore) Stat(id string) (Info, error) {
+	if err := checkID(id); err != nil {
+		return Info{}, err
+	}
+	ms.Lock()
+	defer ms.Unlock()
+	m, ok := ms.maps[id]
+	if !ok {
+		return Info{}, ErrNotExist
+	}
+	return m.info(id), nil
+}
+
+func (ms *MemoryStore) List() ([]Info, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var list []Info
+	for id, m := range ms.maps {
+		list = append(list, m.info(id))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (ms *MemoryStore) Delete(id string) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	ms.Lock()
+	defer ms.Unlock()
+	delete(ms.maps, id)
+	return nil
+}
+
+func (m memoryMap) info(id string) Info {
+	return Info{ID: id, Size: int64(len(m.data)), ModTime: m.modTime, Metadata: m.meta.Copy()}
+}
@@ -0,0 +1,192 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mapstore
+
+import (
+	"errors"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// storeMap returns a 3x2 map with metadata.
+func storeMap(seed int64) *heightmap.Map {
+	return &heightmap.Map{
+		MinZ:     0,
+		MaxZ:     1,
+		Data:     [][]float64{{0, 0.25}, {0.5, 0.75}, {1, 0.5}},
+		Metadata: &heightmap.Metadata{Generator: "flat", Params: map[string]string{"width": "3"}, Seed: seed},
+	}
+}
+
+// TestStores checks that every store keeps the promises of the MapStore interface.
+func TestStores(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		store func(t *testing.T) MapStore
+	}{
+		{"file", func(t *testing.T) MapStore {
+			st, err := NewFileStore(t.TempDir(), heightmap.EncodeOptions{DataType: heightmap.Float64})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return st
+		}},
+		{"memory", func(t *testing.T) MapStore { return NewMemoryStore() }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testStore(t, tc.store(t))
+		})
+	}
+}
+
+func testStore(t *testing.T, st MapStore) {
+	if list, err := st.List(); err != nil || len(list) != 0 {
+		t.Fatalf("empty: got %v %v, want no maps", list, err)
+	}
+	if _, err := st.Get("1-flat"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("get missing: got %v, want %v", err, ErrNotExist)
+	}
+	if _, err := st.Stat("1-flat"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("stat missing: got %v, want %v", err, ErrNotExist)
+	}
+	if err := st.Delete("1-flat"); err != nil {
+		t.Errorf("delete missing: unexpected error %v", err)
+	}
+
+	for _, id := range []string{"2-flat", "1-flat", "-3-flat"} {
+		if err := st.Put(id, storeMap(int64(len(id)))); err != nil {
+			t.Fatalf("put %s: unexpected error %v", id, err)
+		}
+	}
+	// replacing a map keeps a single copy of it,
+	// and changing the map after it is saved doesn't change the stored map
+	put := storeMap(1)
+	if err := st.Put("1-flat", put); err != nil {
+		t.Fatalf("put again: unexpected error %v", err)
+	}
+	put.Data[0][0], put.Metadata.Params["width"] = 9, "9"
+
+	want := storeMap(1)
+	hm, err := st.Get("1-flat")
+	if err != nil {
+		t.Fatalf("get: unexpected error %v", err)
+	} else if !reflect.DeepEqual(hm.Data, want.Data) || hm.MinZ != want.MinZ || hm.MaxZ != want.MaxZ {
+		t.Errorf("get: got %+v, want %+v", hm, want)
+	} else if !reflect.DeepEqual(hm.Metadata, want.Metadata) {
+		t.Errorf("get: got metadata %+v, want %+v", hm.Metadata, want.Metadata)
+	}
+
+	// changing the map that was loaded doesn't change the stored map
+	hm.Data[0][0], hm.Metadata.Params["width"], hm.Metadata.Seed = 9, "9", 9
+	if again, err := st.Get("1-flat"); err != nil {
+		t.Fatalf("get again: unexpected error %v", err)
+	} else if !reflect.DeepEqual(again.Data, want.Data) || !reflect.DeepEqual(again.Metadata, want.Metadata) {
+		t.Errorf("get again: got %+v %+v, want %+v %+v", again.Data, again.Metadata, want.Data, want.Metadata)
+	}
+
+	info, err := st.Stat("1-flat")
+	if err != nil {
+		t.Fatalf("stat: unexpected error %v", err)
+	} else if info.ID != "1-flat" || info.Size <= 0 || info.ModTime.IsZero() || !reflect.DeepEqual(info.Metadata, want.Metadata) {
+		t.Errorf("stat: got %+v, want 1-flat with its metadata", info)
+	}
+	info.Metadata.Params["width"] = "9"
+	if again, err := st.Stat("1-flat"); err != nil || !reflect.DeepEqual(again.Metadata, want.Metadata) {
+		t.Errorf("stat again: got %+v %v, want the metadata unchanged", again.Metadata, err)
+	}
+
+	list, err := st.List()
+	if err != nil {
+		t.Fatalf("list: unexpected error %v", err)
+	}
+	var ids []string
+	for _, info := range list {
+		ids = append(ids, info.ID)
+	}
+	if wantIDs := []string{"-3-flat", "1-flat", "2-flat"}; !reflect.DeepEqual(ids, wantIDs) {
+		t.Errorf("list: got %v, want %v", ids, wantIDs)
+	}
+
+	if err := st.Delete("2-flat"); err != nil {
+		t.Errorf("delete: unexpected error %v", err)
+	}
+	if _, err := st.Get("2-flat"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("get deleted: got %v, want %v", err, ErrNotExist)
+	}
+	if list, err := st.List(); err != nil || len(list) != 2 {
+		t.Errorf("list after delete: got %v %v, want 2 maps", list, err)
+	}
+
+	for _, id := range []string{"", "flat", "1-Flat", "1.flat", "../1-flat", "1/flat", "1-flat.hmap"} {
+		if err := st.Put(id, storeMap(1)); err == nil {
+			t.Errorf("put %q: expected an error", id)
+		}
+		if _, err := st.Get(id); err == nil || errors.Is(err, ErrNotExist) {
+			t.Errorf("get %q: got %v, want an invalid id", id, err)
+		}
+		if _, err := st.Stat(id); err == nil || errors.Is(err, ErrNotExist) {
+			t.Errorf("stat %q: got %v, want an invalid id", id, err)
+		}
+		if err := st.Delete(id); err == nil {
+			t.Errorf("delete %q: expected an error", id)
+		}
+	}
+}
+
+func TestFileStoreLegacy(t *testing.T) {
+	dir := t.TempDir()
+	st, err := NewFileStore(dir, heightmap.EncodeOptions{DataType: heightmap.Float32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a legacy map, a stray file, and a leftover temporary file
+	for name, data := range map[string]string{
+		"7.json":             `{"MinZ":0,"MaxZ":1,"Data":[[0,1],[1,0]]}`,
+		"notes.txt":          "not a map",
+		".tmp-8-flat-123456": "partial",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if list, err := st.List(); err != nil || len(list) != 1 || list[0].ID != "7" || list[0].Metadata != nil {
+		t.Fatalf("list: got %+v %v, want the legacy map without metadata", list, err)
+	}
+	if hm, err := st.Get("7"); err != nil || !reflect.DeepEqual(hm.Data, [][]float64{{0, 1}, {1, 0}}) {
+		t.Errorf("get legacy: got %v %v", hm, err)
+	}
+
+	// saving the map again replaces the legacy file
+	if err := st.Put("7", storeMap(7)); err != nil {
+		t.Fatalf("put: unexpected error %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "7.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("legacy file: got %v, want it removed", err)
+	}
+	if info, err := st.Stat("7"); err != nil || info.Metadata == nil || info.Metadata.Seed != 7 {
+		t.Errorf("stat: got %+v %v, want the new map", info, err)
+	}
+	if err := st.Delete("7"); err != nil {
+		t.Fatalf("delete: unexpected error %v", err)
+	}
+	if _, err := st.Get("7"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("get deleted: got %v, want %v", err, ErrNotExist)
+	}
+}
@@ -0,0 +1,85 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package processors defines the interface for steps that change an existing
+// map, such as erosion, and a registry that the command line and the web
+// server use to find them.
+//
+// Processors are registered the same way as generators, from an init function
+// in their own package, and use the same parameter schema.
+package processors
+
+import (
+	"context"
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/generators"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Processor is the interface implemented by every map processor.
+type Processor interface {
+	// Name is the unique name of the processor.
+	// It is used on the command line and in forms, so keep it short and lowercase.
+	Name() string
+	// Description is a one line summary of the processor.
+	Description() string
+	// Parameters is the schema for the parameters accepted by Process.
+	Parameters() []generators.Parameter
+	// Process returns a changed copy of the map; it must not change hm.
+	// The parameters have been validated against the schema before Process is called.
+	// Processors that use randomness must only use rnd, so that the same seed
+	// always gives the same map.
+	// Like generators, processors should report their progress and return
+	// ctx.Err() promptly if the context is canceled.
+	Process(ctx context.Context, hm *heightmap.Map, params generators.Params, rnd *rand.Rand, progress generators.Progress) (*heightmap.Map, error)
+}
+
+// Step returns the description of a run of the processor that is recorded
+// in the provenance of the map.
+// It is written as the arguments to "mapgen process".
+func Step(p Processor, seed int64, params generators.Params) string {
+	var keys []string
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := []string{p.Name(), fmt.Sprintf("--seed=%d", seed)}
+	for _, k := range keys {
+		args = append(args, fmt.Sprintf("--%s=%s", k, params[k]))
+	}
+	return strings.Join(args, " ")
+}
+
+var registry = generators.NewRegistry[Processor]("processor")
+
+// Register makes a processor available by name.
+// It panics if the name is empty or if the name is already registered.
+func Register(p Processor) {
+	registry.Register(p)
+}
+
+// Lookup returns the processor registered with the given name.
+func Lookup(name string) (Processor, bool) {
+	return registry.Lookup(name)
+}
+
+// List returns all the registered processors, sorted by name.
+func List() []Processor {
+	return registry.List()
+}
@@ -0,0 +1,144 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"github.com/mdhender/mapgen/pkg/mapstore"
+	"time"
+)
+
+// imageKey identifies a rendered image.
+// View holds every parameter that changes the image.
+type imageKey struct {
+	id   string
+	view string
+}
+
+// renderedImage is an entry in the image cache.
+type renderedImage struct {
+	png  []byte
+	etag string
+	// modTime is when the map was last saved before it was rendered.
+	// If the map has been saved since, the image is stale.
+	modTime time.Time
+}
+
+func newRenderedImage(png []byte, modTime time.Time) *renderedImage {
+	sum := sha256.Sum256(png)
+	return &renderedImage{
+		png:     png,
+		etag:    `"` + hex.EncodeToString(sum[:16]) + `"`,
+		modTime: modTime,
+	}
+}
+
+func (img *renderedImage) size() int64 {
+	return int64(len(img.png))
+}
+
+// cachedMap is an entry in the map cache.
+// The map is shared, so it must not be changed.
+type cachedMap struct {
+	hm *heightmap.Map
+	// modTime is when the map was saved.
+	// If the map has been saved since, the entry is stale.
+	modTime time.Time
+}
+
+// size returns the approximate memory used by the map.
+func (cm *cachedMap) size() int64 {
+	n := int64(len(cm.hm.Data)) * int64(len(cm.hm.Data[0])) * 8
+	for _, col := range cm.hm.Colors {
+		n += int64(len(col)) * 8
+	}
+	return n
+}
+
+// biomeAreas is an entry in the cache of the areas of the biomes on a map.
+// The areas are shared, so they must not be changed.
+type biomeAreas struct {
+	areas []heightmap.BiomeArea
+	// modTime is when the map was saved.
+	// If the map has been saved since, the entry is stale.
+	modTime time.Time
+}
+
+// size returns the approximate memory used by the areas.
+func (ba *biomeAreas) size() int64 {
+	return 64 + int64(len(ba.areas))*48
+}
+
+// loadMap returns the map from the cache, loading it from the store if needed.
+// The map is shared by every request, so callers must transform a copy.
+func (s *Server) loadMap(info mapstore.Info) (*heightmap.Map, error) {
+	if cm, ok := s.maps.Get(info.ID); ok && cm.modTime.Equal(info.ModTime) {
+		return cm.hm, nil
+	}
+	hm, err := s.store.Get(info.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.maps.Add(info.ID, &cachedMap{hm: hm, modTime: info.ModTime})
+	return hm, nil
+}
+
+// peekMap returns the map from the cache if it is there and loads it from
+// the store, without caching it, if it isn't.
+// Thumbnails use it so that drawing the gallery doesn't push the maps
+// that are being viewed out of the cache.
+func (s *Server) peekMap(info mapstore.Info) (*heightmap.Map, error) {
+	if cm, ok := s.maps.Get(info.ID); ok && cm.modTime.Equal(info.ModTime) {
+		return cm.hm, nil
+	}
+	return s.store.Get(info.ID)
+}
+
+// biomeAreas returns the areas of the biomes on the map drawn with the view,
+// from the cache if they have been computed since the map was saved.
+// The map is shared, so only a transformed copy is used.
+func (s *Server) biomeAreas(info mapstore.Info, hm *heightmap.Map, v view) ([]heightmap.BiomeArea, error) {
+	key := imageKey{id: info.ID, view: v.climateQuery()}
+	if ba, ok := s.biomes.Get(key); ok && ba.modTime.Equal(info.ModTime) {
+		return ba.areas, nil
+	}
+	m, err := v.Transform.ApplyWithin(hm, s.generators.maxWidth, s.generators.maxHeight)
+	if err != nil {
+		return nil, err
+	}
+	c, err := v.Style.Climate(m)
+	if err != nil {
+		return nil, err
+	}
+	ba := &biomeAreas{areas: c.BiomeAreas(), modTime: info.ModTime}
+	s.biomes.Add(key, ba)
+	return ba.areas, nil
+}
+
+// invalidate drops everything cached for the map.
+// It must be called whenever a map is saved.
+func (s *Server) invalidate(id string) {
+	s.maps.Remove(id)
+	s.images.RemoveFunc(func(key imageKey) bool {
+		return key.id == id
+	})
+	s.biomes.RemoveFunc(func(key imageKey) bool {
+		return key.id == id
+	})
+}
@@ -17,20 +17,26 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/mdhender/mapgen/pkg/generators/flat"
-	"github.com/mdhender/mapgen/pkg/generators/fractal"
-	"github.com/mdhender/mapgen/pkg/generators/olsson"
+	"github.com/mdhender/mapgen/pkg/generators"
 	"github.com/mdhender/mapgen/pkg/heightmap"
+	"github.com/mdhender/mapgen/pkg/jobs"
+	"github.com/mdhender/mapgen/pkg/lru"
+	"github.com/mdhender/mapgen/pkg/mapstore"
+	"github.com/mdhender/mapgen/pkg/processors"
+	"github.com/mdhender/mapgen/pkg/way"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -89,21 +95,15 @@ func (s *Server) cookiesOptOutHandler() http.HandlerFunc {
 
 func (s *Server) generateHandler() http.HandlerFunc {
 	type request struct {
-		seed          int64
-		generator     string
-		force         bool
-		useHSL        bool
-		wrap          bool
-		height, width int
-		iterations    int
-		secret        string
+		seed      int64
+		generator string
+		params    generators.Params
+		force     bool
+		useHSL    bool
+		secret    string
 	}
 
-	var lock sync.Mutex
-
 	return func(w http.ResponseWriter, r *http.Request) {
-		started := time.Now()
-
 		if err := r.ParseForm(); err != nil {
 			//log.Printf("%s %s: %v\n", r.Method, r.URL, err)
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -113,9 +113,6 @@ func (s *Server) generateHandler() http.HandlerFunc {
 		// get form values
 		var err error
 		var req request
-		req.height, req.width = s.generators.height, s.generators.width
-		req.iterations = s.generators.iterations
-
 		if req.seed, err = pfvAsInt64(r, "seed"); err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
@@ -125,9 +122,6 @@ func (s *Server) generateHandler() http.HandlerFunc {
 		} else if req.secret, _ = pfvAsString(r, "secret"); err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if req.wrap, _ = pfvAsOptBool(r, "wrap"); err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
-			return
 		} else if req.force, _ = pfvAsOptBool(r, "force"); err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
@@ -135,147 +129,271 @@ func (s *Server) generateHandler() http.HandlerFunc {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
 		}
+		g, ok := generators.Lookup(req.generator)
+		if !ok {
+			http.Error(w, fmt.Sprintf("%q: unknown generator", req.generator), http.StatusBadRequest)
+			return
+		}
+		// generator parameters are named "generator.parameter" in the form
+		schema := s.parameters(g)
+		if req.params, err = generators.Validate(schema, pfvAsParams(r, g.Name(), schema)); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
 		log.Printf("%s %s: %+v\n", r.Method, r.URL, req)
 
-		fname := fmt.Sprintf("%d.json", req.seed)
-		log.Printf("%s %s: %s\n", r.Method, r.URL, fname)
-
-		lock.Lock()
-		defer func() {
-			lock.Unlock()
-		}()
+		meta := &heightmap.Metadata{
+			Generator: g.Name(),
+			Params:    req.params,
+			Seed:      req.seed,
+			Version:   s.version,
+		}
+		id := meta.ID()
+		log.Printf("%s %s: %s\n", r.Method, r.URL, id)
 
 		// if the map already exists, we don't need to rebuild it
-		// (unless the user clicked the force flag)
-		createMap := true
-		if _, err := os.Stat(fname); err == nil { // map exists
-			createMap = false
-			if req.force {
-				createMap = true
-				log.Printf("%s %s: %s is forced overwrite\n", r.Method, r.URL, fname)
+		// (unless the user clicked the force flag).
+		// if it was created with different parameters, we don't
+		// want to overwrite it without the force flag.
+		if info, err := s.store.Stat(id); err == nil && !req.force { // map exists
+			if info.Metadata != nil && !info.Metadata.SameAs(meta) {
+				http.Error(w, fmt.Sprintf("map %s exists with different parameters: %s", id, info.Metadata.CommandLine()), http.StatusConflict)
+				return
 			}
+			log.Printf("%s %s: %s is cached\n", r.Method, r.URL, id)
+			http.Redirect(w, r, viewURL(id, hslView(req.useHSL)), http.StatusSeeOther)
+			return
 		}
 
-		if !createMap {
-			log.Printf("%s %s: %s is cached\n", r.Method, r.URL, fname)
+		// requests for the same map with the same parameters share a job.
+		// forced requests get their own job, so that they aren't satisfied
+		// by a job that found the map already created and left it alone.
+		key := meta.CommandLine()
+		if req.force {
+			key += " --force"
+		}
+		job, joined, err := s.jobs.Submit(key, func(ctx context.Context, progress func(done, total int)) (string, error) {
+			return id, s.generateMap(ctx, g, meta, req.force, progress)
+		})
+		if errors.Is(err, jobs.ErrQueueFull) {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+		if joined {
+			log.Printf("%s %s: %s joined job %s\n", r.Method, r.URL, id, job.ID())
 		} else {
-			log.Printf("%s %s: %s is being created\n", r.Method, r.URL, fname)
-
-			// create a new random source
-			rnd := rand.New(rand.NewSource(req.seed))
-			// generate it
-			var hm *heightmap.Map
-			switch req.generator {
-			case "flat-earth":
-				hm = flat.Generate(1280, 640, 10_000, req.wrap, rnd)
-			case "fractal":
-				hm = fractal.Generate(5, rnd)
-			case "olsson":
-				hm = olsson.Generate(10_000, rnd)
-			default:
-				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-				return
-			}
-
-			// save it
-			data, err := json.Marshal(hm)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
-				return
-			} else if err = os.WriteFile(fname, data, 0644); err != nil {
-				http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
-				return
-			}
-			log.Printf("%s %s: created %s elapsed %v\n", r.Method, r.URL, fname, time.Now().Sub(started))
+			log.Printf("%s %s: %s queued job %s\n", r.Method, r.URL, id, job.ID())
 		}
 
-		http.Redirect(w, r, fmt.Sprintf("/view/%d/pct-water/33/pct-ice/8/shift-x/0/shift-y/0/rotate/false/hsl/%v", req.seed, req.useHSL), http.StatusSeeOther)
+		http.Redirect(w, r, fmt.Sprintf("/jobs/%s?hsl=%v", job.ID(), req.useHSL), http.StatusSeeOther)
 	}
 }
 
-func (s *Server) imageHandler() http.HandlerFunc {
-	type request struct {
-		Seed     int64
-		PctWater int
-		PctIce   int
-		ShiftX   int
-		ShiftY   int
-		Rotate   bool
-		UseHSL   bool
+// generateMap runs the generator and saves the map.
+// Only one map with a given id is generated at a time.
+// The generator is stopped if the context is canceled or if it runs
+// longer than the maximum generation time.
+func (s *Server) generateMap(ctx context.Context, g generators.Generator, meta *heightmap.Metadata, force bool, progress generators.Progress) error {
+	id := meta.ID()
+	unlock := s.locks.lock(id)
+	defer unlock()
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s: imageHandler: entered\n", r.Method, r.URL)
+	// another job may have created the map while this one was waiting
+	if info, err := s.store.Stat(id); err == nil && !force {
+		if info.Metadata != nil && !info.Metadata.SameAs(meta) {
+			return fmt.Errorf("map %s exists with different parameters: %s", id, info.Metadata.CommandLine())
+		}
+		log.Printf("generate: %s is cached\n", id)
+		return nil
+	}
+	log.Printf("generate: %s is being created\n", id)
 
-		var err error
-		var req request
-		if req.Seed, err = wayParmAsInt64(r.Context(), "seed"); err != nil {
+	started := time.Now()
+	// create a new random source
+	rnd := rand.New(rand.NewSource(meta.Seed))
+	// generate it
+	if s.generators.maxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.generators.maxTime)
+		defer cancel()
+	}
+	hm, err := g.Generate(ctx, meta.Params, rnd, progress)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("generate: %s: stopped after %v\n", id, s.generators.maxTime)
+		return fmt.Errorf("generator took longer than %v", s.generators.maxTime)
+	} else if err != nil {
+		log.Printf("generate: %s: %v\n", id, err)
+		return err
+	}
+	// the parameters are checked against the limits, but some generators,
+	// like fractal with a width of 0, choose the size of the map themselves
+	if maxx, maxy := len(hm.Data), len(hm.Data[0]); maxx > s.generators.maxWidth || maxy > s.generators.maxHeight {
+		log.Printf("generate: %s: %dx%d is too large\n", id, maxx, maxy)
+		return fmt.Errorf("%dx%d: larger than the %dx%d limit", maxx, maxy, s.generators.maxWidth, s.generators.maxHeight)
+	}
+	meta.Created, meta.Elapsed = started.UTC(), time.Now().Sub(started)
+	hm.Metadata = meta
+
+	// save it
+	if err := s.store.Put(id, hm); err != nil {
+		return err
+	}
+	s.invalidate(id)
+	log.Printf("generate: created %s elapsed %v\n", id, time.Now().Sub(started))
+	return nil
+}
+
+// hydrologyHandler serves the rivers and lakes of a map as GeoJSON.
+// It takes the same settings as the image, so the water matches the image.
+func (s *Server) hydrologyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := wayParmAsId(r.Context(), "id")
+		if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if req.PctWater, err = wayParmAsInt(r.Context(), "pctWater"); err != nil {
+		}
+		v, err := viewFromQuery(r.URL.Query())
+		if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if req.PctIce, err = wayParmAsInt(r.Context(), "pctIce"); err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+		} else if v.Rivers <= 0 && !v.Lakes {
+			http.Error(w, "rivers or lakes must be drawn", http.StatusBadRequest)
 			return
-		} else if req.ShiftX, err = wayParmAsInt(r.Context(), "shiftX"); err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+		}
+
+		info, err := s.store.Stat(id)
+		if errors.Is(err, mapstore.ErrNotExist) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 			return
-		} else if req.ShiftY, err = wayParmAsInt(r.Context(), "shiftY"); err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 			return
-		} else if req.Rotate, err = wayParmAsBool(r.Context(), "rotate"); err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+		}
+		m, err := s.loadMap(info)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 			return
-		} else if req.UseHSL, err = wayParmAsBool(r.Context(), "hsl"); err != nil {
+		}
+		// transforms return a copy, so the shared map isn't changed
+		if m, err = v.Transform.ApplyWithin(m, s.generators.maxWidth, s.generators.maxHeight); err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
 		}
-		log.Printf("%s %s: %+v\n", r.Method, r.URL, req)
+		bb, err := m.Drainage(m.SeaLevel(v.PctWater), v.Lakes && v.Flood).GeoJSON(float64(v.Rivers))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
 
-		var m *heightmap.Map
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+"-hydrology.geojson"))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bb)
+	}
+}
 
-		// load map from json
-		fname := fmt.Sprintf("%d.json", req.Seed)
-		data, err := os.ReadFile(fname)
+func (s *Server) imageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := wayParmAsId(r.Context(), "id")
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if err = json.Unmarshal(data, &m); err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+		}
+		v, err := viewFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
 		}
-		log.Printf("%s %s: loaded %s\n", r.Method, r.URL, fname)
+		log.Printf("%s %s: %+v\n", r.Method, r.URL, v)
 
-		// transform it
-		if req.Rotate {
-			m.Rotate(true)
+		info, err := s.store.Stat(id)
+		if errors.Is(err, mapstore.ErrNotExist) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
 		}
-		m.ShiftXY(req.ShiftX, req.ShiftY)
 
-		if req.UseHSL {
-			if err = m.ColorHSL(req.PctWater, req.PctIce, heightmap.WaterColors, heightmap.AlternateLandColors, heightmap.IceColors); err != nil {
-				log.Printf("%s %s: imageHandler: error: %v\n", r.Method, r.URL, err)
+		key := imageKey{id: id, view: v.query()}
+		img, ok := s.images.Get(key)
+		if !ok || !img.modTime.Equal(info.ModTime) {
+			// load map
+			m, err := s.loadMap(info)
+			if errors.Is(err, mapstore.ErrNotExist) {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			} else if err != nil {
 				http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 				return
 			}
-		} else {
-			if err = m.Color(req.PctWater, 100-req.PctIce, req.PctIce, heightmap.WaterColors, heightmap.LandColors, heightmap.IceColors); err != nil {
-				http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			log.Printf("%s %s: loaded %s\n", r.Method, r.URL, id)
+
+			// render never changes the map, which is shared
+			bb, err := v.render(m, s.generators.maxWidth, s.generators.maxHeight)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 				return
 			}
+			img = newRenderedImage(bb, info.ModTime)
+			s.images.Add(key, img)
 		}
 
-		// convert image to PNG
-		bb, err := m.AsPNG()
+		// the image changes when the map is regenerated, so browsers
+		// must check the etag before using a copy from their cache.
+		w.Header().Set("Cache-Control", "public, no-cache")
+		w.Header().Set("ETag", img.etag)
+		http.ServeContent(w, r, "map.png", time.Time{}, bytes.NewReader(img.png))
+	}
+}
+
+// thumbHandler serves a small image of the map for the gallery.
+func (s *Server) thumbHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := wayParmAsId(r.Context(), "id")
 		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+
+		info, err := s.store.Stat(id)
+		if errors.Is(err, mapstore.ErrNotExist) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 			return
 		}
 
-		w.Header().Set("Content-Type", "image/png")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write(bb)
+		key := imageKey{id: id, view: "thumb"}
+		img, ok := s.images.Get(key)
+		if !ok || !img.modTime.Equal(info.ModTime) {
+			m, err := s.peekMap(info)
+			if errors.Is(err, mapstore.ErrNotExist) {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+				return
+			}
+			bb, err := thumbnail(m)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+				return
+			}
+			img = newRenderedImage(bb, info.ModTime)
+			s.images.Add(key, img)
+		}
+
+		w.Header().Set("Cache-Control", "public, no-cache")
+		w.Header().Set("ETag", img.etag)
+		http.ServeContent(w, r, "thumb.png", time.Time{}, bytes.NewReader(img.png))
 	}
 }
 
@@ -297,6 +415,147 @@ func (s *Server) indexHandler() http.HandlerFunc {
 	}
 }
 
+func (s *Server) jobHandler() http.HandlerFunc {
+	type response struct {
+		jobs.Snapshot
+		View string `json:"view,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := s.jobs.Get(way.Param(r.Context(), "id"))
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		useHSL, _ := strconv.ParseBool(r.URL.Query().Get("hsl"))
+
+		resp := response{Snapshot: job.Snapshot()}
+		if resp.Status == jobs.Done {
+			resp.View = viewURL(resp.Result, hslView(useHSL))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// jobCancelHandler stops a queued or running job.
+func (s *Server) jobCancelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := way.Param(r.Context(), "id")
+		if !s.jobs.Cancel(id) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		log.Printf("%s %s: canceled job %s\n", r.Method, r.URL, id)
+		http.Redirect(w, r, "/jobs/"+id, http.StatusSeeOther)
+	}
+}
+
+// jobEventsHandler streams the status of a job as server-sent events.
+// A "progress" event is sent whenever the status changes, followed by
+// a "done", "failed", or "canceled" event when the job finishes.
+// The data for every event is the JSON returned by jobHandler.
+func (s *Server) jobEventsHandler() http.HandlerFunc {
+	type response struct {
+		jobs.Snapshot
+		View string `json:"view,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := s.jobs.Get(way.Param(r.Context(), "id"))
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		useHSL, _ := strconv.ParseBool(r.URL.Query().Get("hsl"))
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+
+		send := func(event string, resp response) {
+			data, _ := json.Marshal(resp)
+			_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+			flusher.Flush()
+		}
+
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		var last jobs.Snapshot
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-job.Done():
+				resp := response{Snapshot: job.Snapshot()}
+				if resp.Status == jobs.Done {
+					resp.View = viewURL(resp.Result, hslView(useHSL))
+				}
+				send(string(resp.Status), resp)
+				return
+			case <-ticker.C:
+				snap := job.Snapshot()
+				if snap.Status != last.Status || snap.Steps != last.Steps || snap.Total != last.Total {
+					send("progress", response{Snapshot: snap})
+					last = snap
+				}
+			}
+		}
+	}
+}
+
+// legacyHandler redirects the paths used by older versions for the
+// view and image pages to the current paths.
+func (s *Server) legacyHandler(page string) http.HandlerFunc {
+	type request struct {
+		Id       string
+		PctWater int
+		PctIce   int
+		ShiftX   int
+		ShiftY   int
+		Rotate   bool
+		UseHSL   bool
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		var req request
+		if req.Id, err = wayParmAsId(r.Context(), "id"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.PctWater, err = wayParmAsInt(r.Context(), "pctWater"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.PctIce, err = wayParmAsInt(r.Context(), "pctIce"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.ShiftX, err = wayParmAsInt(r.Context(), "shiftX"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.ShiftY, err = wayParmAsInt(r.Context(), "shiftY"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.Rotate, err = wayParmAsBool(r.Context(), "rotate"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.UseHSL, err = wayParmAsBool(r.Context(), "hsl"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+
+		v := legacyView(req.PctWater, req.PctIce, req.ShiftX, req.ShiftY, req.Rotate, req.UseHSL)
+		http.Redirect(w, r, "/"+page+"/"+req.Id+"?"+v.query(), http.StatusMovedPermanently)
+	}
+}
+
 func (s *Server) loginPostHandler() http.HandlerFunc {
 	type request struct {
 		name   string
@@ -344,35 +603,204 @@ func (s *Server) logoutHandler() http.HandlerFunc {
 
 func (s *Server) manageHandler() http.HandlerFunc {
 	rr := Renderer{}
-	for _, tmpl := range []string{"layout", "navbar", "footer", "manage"} {
+	for _, tmpl := range []string{"layout", "navbar", "footer", "manage", "job"} {
 		rr.files = append(rr.files, filepath.Join(s.templates, tmpl+".gohtml"))
 	}
 
+	type generator struct {
+		Name        string
+		Description string
+		Checked     bool
+		Parameters  []formField
+	}
+	type image struct {
+		Id        string
+		Generator string
+		Created   string
+	}
 	type request struct {
-		Generators struct {
-			Impact     bool
-			ImpactWrap bool
-			Fractal    bool
-			Olsson     bool
-		}
-		Images []string
+		Generators []generator
+		Images     []image
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := request{}
-		if files, err := os.ReadDir("."); err == nil {
-			for _, file := range files {
-				if name := file.Name(); strings.HasSuffix(name, ".json") {
-					req.Images = append(req.Images, name[:len(name)-5])
+		for n, g := range generators.List() {
+			req.Generators = append(req.Generators, generator{
+				Name:        g.Name(),
+				Description: g.Description(),
+				Checked:     n == 0,
+				Parameters:  formFields(g.Name(), s.parameters(g)),
+			})
+		}
+		if list, err := s.store.List(); err == nil {
+			for _, info := range list {
+				img := image{Id: info.ID}
+				if meta := info.Metadata; meta != nil {
+					img.Generator = meta.Generator
+					if !meta.Created.IsZero() {
+						img.Created = meta.Created.Format("2006-01-02 15:04")
+					}
 				}
+				req.Images = append(req.Images, img)
 			}
 		}
-		sort.Strings(req.Images)
 
 		s.render(w, r, rr, req)
 	}
 }
 
+func (s *Server) processHandler() http.HandlerFunc {
+	type request struct {
+		id        string
+		processor string
+		seed      int64
+		params    generators.Params
+		output    string
+		force     bool
+		useHSL    bool
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		// get form values
+		var err error
+		var req request
+		if req.id, err = pfvAsString(r, "id"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if !mapstore.ValidID(req.id) {
+			http.Error(w, fmt.Sprintf("id: %q: invalid map id", req.id), http.StatusBadRequest)
+			return
+		} else if req.processor, err = pfvAsString(r, "processor"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.seed, err = pfvAsInt64(r, "seed"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.force, err = pfvAsOptBool(r, "force"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.useHSL, err = pfvAsOptBool(r, "use-hsl"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		p, ok := processors.Lookup(req.processor)
+		if !ok {
+			http.Error(w, fmt.Sprintf("%q: unknown processor", req.processor), http.StatusBadRequest)
+			return
+		}
+		if req.output = r.PostFormValue("output"); req.output == "" {
+			req.output = req.id + "-" + p.Name()
+		} else if !mapstore.ValidID(req.output) {
+			http.Error(w, fmt.Sprintf("output: %q: invalid map id", req.output), http.StatusBadRequest)
+			return
+		}
+		// processor parameters are named "processor.parameter" in the form
+		if req.params, err = generators.Validate(p.Parameters(), pfvAsParams(r, p.Name(), p.Parameters())); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		log.Printf("%s %s: %+v\n", r.Method, r.URL, req)
+
+		if _, err := s.store.Stat(req.id); err != nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if _, err := s.store.Stat(req.output); err == nil && !req.force {
+			http.Error(w, fmt.Sprintf("map %s exists", req.output), http.StatusConflict)
+			return
+		}
+
+		// requests for the same output with the same steps share a job
+		step := processors.Step(p, req.seed, req.params)
+		job, joined, err := s.jobs.Submit("process "+req.id+" "+req.output+" "+step, func(ctx context.Context, progress func(done, total int)) (string, error) {
+			return req.output, s.processMap(ctx, p, req.id, req.output, req.seed, req.params, progress)
+		})
+		if errors.Is(err, jobs.ErrQueueFull) {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+		if joined {
+			log.Printf("%s %s: %s joined job %s\n", r.Method, r.URL, req.output, job.ID())
+		} else {
+			log.Printf("%s %s: %s queued job %s\n", r.Method, r.URL, req.output, job.ID())
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/jobs/%s?hsl=%v", job.ID(), req.useHSL), http.StatusSeeOther)
+	}
+}
+
+// processMap runs the processor on a copy of the map and saves the result as output.
+// Only one map with a given output id is created at a time.
+// The processor is stopped if the context is canceled or if it runs
+// longer than the maximum generation time.
+func (s *Server) processMap(ctx context.Context, p processors.Processor, id, output string, seed int64, params generators.Params, progress generators.Progress) error {
+	unlock := s.locks.lock(output)
+	defer unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	info, err := s.store.Stat(id)
+	if err != nil {
+		return err
+	}
+	// the map is shared with other requests; processors return a new map and leave it alone
+	hm, err := s.loadMap(info)
+	if err != nil {
+		return err
+	}
+	log.Printf("process: %s is being created from %s\n", output, id)
+
+	started := time.Now()
+	if s.generators.maxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.generators.maxTime)
+		defer cancel()
+	}
+	nm, err := p.Process(ctx, hm, params, rand.New(rand.NewSource(seed)), progress)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("process: %s: stopped after %v\n", output, s.generators.maxTime)
+		return fmt.Errorf("processor took longer than %v", s.generators.maxTime)
+	} else if err != nil {
+		log.Printf("process: %s: %v\n", output, err)
+		return err
+	}
+	nm.Metadata = hm.Metadata.Processed(processors.Step(p, seed, params))
+
+	// save it
+	if err := s.store.Put(output, nm); err != nil {
+		return err
+	}
+	s.invalidate(output)
+	log.Printf("process: created %s elapsed %v\n", output, time.Now().Sub(started))
+	return nil
+}
+
+// statsHandler reports the statistics for the caches.
+func (s *Server) statsHandler() http.HandlerFunc {
+	type response struct {
+		Images lru.Stats `json:"images"`
+		Maps   lru.Stats `json:"maps"`
+		Biomes lru.Stats `json:"biomes"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response{Images: s.images.Stats(), Maps: s.maps.Stats(), Biomes: s.biomes.Stats()}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
 func staticHandler(root, pfx string) http.HandlerFunc {
 	root = filepath.Clean(root)
 	if sb, err := os.Stat(root); err != nil {
@@ -487,47 +915,146 @@ func staticFileHandler(root, name string) http.HandlerFunc {
 
 func (s *Server) viewHandler() http.HandlerFunc {
 	rr := Renderer{}
-	for _, tmpl := range []string{"layout", "navbar", "footer", "view"} {
+	for _, tmpl := range []string{"layout", "navbar", "footer", "view", "job"} {
 		rr.files = append(rr.files, filepath.Join(s.templates, tmpl+".gohtml"))
 	}
 
+	type param struct {
+		Name, Value string
+	}
+	type provenance struct {
+		Generator   string
+		Params      []param
+		Seed        int64
+		Version     string
+		Created     string
+		Elapsed     string
+		CommandLine string
+		Source      string   // id of the map this was processed from
+		Processing  []string // steps applied after the map was generated
+	}
+	type processor struct {
+		Name        string
+		Description string
+		Checked     bool
+		Parameters  []formField
+	}
+	type biome struct {
+		Name    string
+		Color   string // as #rrggbb
+		Pixels  int
+		Area    string // in square kilometers
+		Percent string
+	}
 	type request struct {
-		Id       int64
-		PctWater int
-		PctIce   int
-		ShiftX   int
-		ShiftY   int
-		Rotate   bool
-		UseHSL   bool
+		Id         string
+		PctWater   int
+		PctIce     int
+		UseHSL     bool
+		Mode       string
+		Modes      []string
+		Light      heightmap.Light
+		Blend      float64
+		FalseColor bool
+		Rivers     int
+		Lakes      bool
+		Flood      bool
+		Transform  string
+		Transforms []string // usage for each transform
+		Image      string
+		Hydrology  string // link to the rivers and lakes as GeoJSON, if they are drawn
+		WrapX      bool   // clicking the image may shift the map horizontally
+		WrapY      bool   // clicking the image may shift the map vertically
+		Provenance *provenance
+		Processors []processor // only shown to users who can save maps
+		Biomes     []biome     // only shown when the map is drawn by biome
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("%s %s: viewHandler: entered\n", r.Method, r.URL)
 		var err error
 		var req request
-		if req.Id, err = wayParmAsInt64(r.Context(), "id"); err != nil {
+		if req.Id, err = wayParmAsId(r.Context(), "id"); err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if req.PctWater, err = wayParmAsInt(r.Context(), "pctWater"); err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
-			return
-		} else if req.PctIce, err = wayParmAsInt(r.Context(), "pctIce"); err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
-			return
-		} else if req.ShiftX, err = wayParmAsInt(r.Context(), "shiftX"); err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
-			return
-		} else if req.ShiftY, err = wayParmAsInt(r.Context(), "shiftY"); err != nil {
+		}
+		v, err := viewFromQuery(r.URL.Query())
+		if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if req.Rotate, err = wayParmAsBool(r.Context(), "rotate"); err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+		}
+		req.PctWater, req.PctIce, req.UseHSL = v.PctWater, v.PctIce, v.UseHSL
+		req.Mode, req.Light, req.Blend, req.FalseColor = string(v.Mode), v.Light, v.Blend, v.FalseColor
+		for _, mode := range heightmap.Modes {
+			req.Modes = append(req.Modes, string(mode))
+		}
+		req.Transform, req.Transforms = v.Transform.String(), heightmap.TransformUsage()
+		req.Image, req.Rivers, req.Lakes, req.Flood = imageURL(req.Id, v), v.Rivers, v.Lakes, v.Flood
+		if v.Rivers > 0 || v.Lakes {
+			req.Hydrology = hydrologyURL(req.Id, v)
+		}
+
+		info, err := s.store.Stat(req.Id)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 			return
-		} else if req.UseHSL, err = wayParmAsBool(r.Context(), "hsl"); err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+		}
+		// the image request loads the map right after this, so it's cheap
+		if hm, err := s.loadMap(info); err != nil {
+			log.Printf("%s %s: viewHandler: %v\n", r.Method, r.URL, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
+		} else {
+			req.WrapX, req.WrapY = hm.WrapX, hm.WrapY
+			if v.Mode == heightmap.ModeBiome {
+				if areas, err := s.biomeAreas(info, hm, v); err != nil {
+					log.Printf("%s %s: viewHandler: %v\n", r.Method, r.URL, err)
+				} else {
+					for _, a := range areas {
+						rgb := a.Biome.Color()
+						req.Biomes = append(req.Biomes, biome{
+							Name:    a.Biome.String(),
+							Color:   fmt.Sprintf("#%02x%02x%02x", rgb.R, rgb.G, rgb.B),
+							Pixels:  a.Pixels,
+							Area:    fmt.Sprintf("%.0f", a.Area),
+							Percent: fmt.Sprintf("%.1f", a.Percent),
+						})
+					}
+				}
+			}
+		}
+		if meta := info.Metadata; meta != nil {
+			req.Provenance = &provenance{
+				Generator:   meta.Generator,
+				Seed:        meta.Seed,
+				Version:     meta.Version,
+				Elapsed:     meta.Elapsed.Round(time.Millisecond).String(),
+				CommandLine: meta.CommandLine(),
+				Processing:  meta.Processing,
+			}
+			if len(meta.Processing) != 0 {
+				req.Provenance.Source = meta.ID()
+			}
+			if !meta.Created.IsZero() {
+				req.Provenance.Created = meta.Created.Format(time.RFC3339)
+			}
+			for k, v := range meta.Params {
+				req.Provenance.Params = append(req.Provenance.Params, param{Name: k, Value: v})
+			}
+			sort.Slice(req.Provenance.Params, func(i, j int) bool {
+				return req.Provenance.Params[i].Name < req.Provenance.Params[j].Name
+			})
+		}
+		if s.currentUser(r).IsAuthenticated {
+			for n, p := range processors.List() {
+				req.Processors = append(req.Processors, processor{
+					Name:        p.Name(),
+					Description: p.Description(),
+					Checked:     n == 0,
+					Parameters:  formFields(p.Name(), p.Parameters()),
+				})
+			}
 		}
-		log.Printf("%s %s: hsl %+v\n", r.Method, r.URL, req.UseHSL)
 
 		s.render(w, r, rr, req)
 	}
@@ -535,43 +1062,68 @@ func (s *Server) viewHandler() http.HandlerFunc {
 
 func (s *Server) viewPostHandler() http.HandlerFunc {
 	type request struct {
-		Id       int64
-		PctWater int
-		PctIce   int
-		ShiftX   int
-		ShiftY   int
-		Rotate   bool
-		UseHSL   bool
+		Id string
+		v  view
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("%s %s: viewPostHandler: entered\n", r.Method, r.URL)
 		var err error
 		var req request
-		if req.Id, err = pfvAsInt64(r, "id"); err != nil {
+		if req.Id, err = pfvAsString(r, "id"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if !mapstore.ValidID(req.Id) {
+			http.Error(w, fmt.Sprintf("%q: invalid id", "id"), http.StatusBadRequest)
+			return
+		} else if req.v.PctWater, err = pfvAsInt(r, "pct_water"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.v.PctIce, err = pfvAsInt(r, "pct_ice"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.v.UseHSL, err = pfvAsOptBool(r, "use-hsl"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.v.Transform, err = heightmap.ParsePipeline(r.PostFormValue("transform")); err != nil {
+			http.Error(w, fmt.Sprintf("transform: %v", err), http.StatusBadRequest)
+			return
+		} else if req.v.Mode, err = heightmap.ParseMode(r.PostFormValue("mode")); err != nil {
+			http.Error(w, fmt.Sprintf("mode: %v", err), http.StatusBadRequest)
+			return
+		} else if req.v.Light.Azimuth, err = pfvAsFloat(r, "azimuth"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.v.Light.Altitude, err = pfvAsFloat(r, "altitude"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.v.Light.Exaggeration, err = pfvAsFloat(r, "exaggeration"); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		} else if req.v.Light.MultiDirectional, err = pfvAsOptBool(r, "multi"); err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if req.PctWater, err = pfvAsInt(r, "pct_water"); err != nil {
+		} else if req.v.Blend, err = pfvAsFloat(r, "blend"); err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if req.PctIce, err = pfvAsInt(r, "pct_ice"); err != nil {
+		} else if req.v.FalseColor, err = pfvAsOptBool(r, "false-color"); err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if req.ShiftX, err = pfvAsInt(r, "shift_x"); err != nil {
+		} else if req.v.Rivers, err = pfvAsInt(r, "rivers"); err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if req.ShiftY, err = pfvAsInt(r, "shift_y"); err != nil {
+		} else if req.v.Lakes, err = pfvAsOptBool(r, "lakes"); err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if req.Rotate, err = pfvAsOptBool(r, "rotate"); err != nil {
+		} else if req.v.Flood, err = pfvAsOptBool(r, "flood"); err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if req.UseHSL, err = pfvAsOptBool(r, "use-hsl"); err != nil {
+		} else if err = req.v.Style.Validate(); err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
 		}
 		//log.Printf("%s %s: %+v\n", r.Method, r.URL, req)
 
-		http.Redirect(w, r, fmt.Sprintf("/view/%d/pct-water/%d/pct-ice/%d/shift-x/%d/shift-y/%d/rotate/%v/hsl/%v", req.Id, req.PctWater, req.PctIce, req.ShiftX, req.ShiftY, req.Rotate, req.UseHSL), http.StatusSeeOther)
+		http.Redirect(w, r, viewURL(req.Id, req.v), http.StatusSeeOther)
 	}
 }
@@ -22,6 +22,8 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"fmt"
+	"github.com/mdhender/mapgen/pkg/generators"
+	"github.com/mdhender/mapgen/pkg/mapstore"
 	"github.com/mdhender/mapgen/pkg/way"
 	"image"
 	"image/png"
@@ -63,6 +65,33 @@ func pfvAsInt(r *http.Request, key string) (int, error) {
 	return val, nil
 }
 
+func pfvAsFloat(r *http.Request, key string) (float64, error) {
+	raw := r.PostFormValue(key)
+	if raw == "" {
+		return 0, fmt.Errorf("%q: missing", key)
+	}
+	val, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q: %w", key, err)
+	}
+	return val, nil
+}
+
+// pfvAsParams returns the values of the parameters in the schema.
+// Each parameter is named "prefix.parameter" in the form.
+func pfvAsParams(r *http.Request, prefix string, schema []generators.Parameter) generators.Params {
+	values := generators.Params{}
+	for _, parm := range schema {
+		if parm.Kind == generators.Bool {
+			val, _ := pfvAsOptBool(r, prefix+"."+parm.Name)
+			values[parm.Name] = fmt.Sprintf("%v", val)
+		} else if val := r.PostFormValue(prefix + "." + parm.Name); val != "" {
+			values[parm.Name] = val
+		}
+	}
+	return values
+}
+
 func pfvAsString(r *http.Request, key string) (string, error) {
 	raw := r.PostFormValue(key)
 	if raw == "" {
@@ -88,6 +117,14 @@ func wayParmAsBool(ctx context.Context, param string) (bool, error) {
 	return val == "on" || val == "true" || val == "yes", nil
 }
 
+func wayParmAsId(ctx context.Context, param string) (string, error) {
+	val := way.Param(ctx, param)
+	if !mapstore.ValidID(val) {
+		return "", fmt.Errorf("%q: invalid id", param)
+	}
+	return val, nil
+}
+
 func wayParmAsInt(ctx context.Context, param string) (int, error) {
 	val, err := strconv.Atoi(way.Param(ctx, param))
 	if err != nil {
@@ -103,3 +140,33 @@ func wayParmAsInt64(ctx context.Context, param string) (int64, error) {
 	}
 	return val, nil
 }
+
+// formField is a parameter from a schema, as shown in a form.
+type formField struct {
+	Field   string // name of the form field
+	Name    string
+	Usage   string
+	Default string
+	Limits  string
+	IsBool  bool
+}
+
+// formFields returns the fields for the parameters in the schema.
+// Each field is named "prefix.parameter", to match pfvAsParams.
+func formFields(prefix string, schema []generators.Parameter) []formField {
+	var fields []formField
+	for _, parm := range schema {
+		field := formField{
+			Field:   prefix + "." + parm.Name,
+			Name:    parm.Name,
+			Usage:   parm.Usage,
+			Default: parm.Default,
+			IsBool:  parm.Kind == generators.Bool,
+		}
+		if parm.Min != 0 || parm.Max != 0 {
+			field.Limits = fmt.Sprintf("%g to %g", parm.Min, parm.Max)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
@@ -0,0 +1,59 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import "sync"
+
+// idLocks serializes work on a single map while letting
+// work on different maps run concurrently.
+type idLocks struct {
+	sync.Mutex
+	locks map[string]*idLock
+}
+
+type idLock struct {
+	sync.Mutex
+	refs int // number of goroutines holding or waiting for the lock
+}
+
+// lock blocks until the lock for the map is available.
+// The caller must call the returned function to release the lock.
+func (l *idLocks) lock(id string) (unlock func()) {
+	l.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*idLock)
+	}
+	il, ok := l.locks[id]
+	if !ok {
+		il = &idLock{}
+		l.locks[id] = il
+	}
+	il.refs++
+	l.Unlock()
+
+	il.Lock()
+
+	return func() {
+		il.Unlock()
+		l.Lock()
+		il.refs--
+		if il.refs == 0 {
+			delete(l.locks, id)
+		}
+		l.Unlock()
+	}
+}
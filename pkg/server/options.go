@@ -19,7 +19,10 @@ package server
 import (
 	"fmt"
 	"github.com/mdhender/mapgen/pkg/authz"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"github.com/mdhender/mapgen/pkg/mapstore"
 	"path/filepath"
+	"time"
 )
 
 type Options []Option
@@ -43,6 +46,96 @@ func WithCSS(path string) Option {
 	}
 }
 
+// WithDataDir stores maps in the given directory.
+func WithDataDir(path string) Option {
+	return func(s *Server) error {
+		store, err := mapstore.NewFileStore(path, heightmap.EncodeOptions{DataType: heightmap.Float32})
+		if err != nil {
+			return err
+		}
+		s.store = store
+		return nil
+	}
+}
+
+// WithImageCacheSize limits the memory (in bytes) used to cache rendered images.
+func WithImageCacheSize(n int64) Option {
+	return func(s *Server) error {
+		if n < 0 {
+			return fmt.Errorf("image cache size must not be negative")
+		}
+		s.cache.images = n
+		return nil
+	}
+}
+
+// WithIterations sets the default number of iterations for new maps.
+func WithIterations(n int) Option {
+	return func(s *Server) error {
+		if n < 0 {
+			return fmt.Errorf("iterations must not be negative")
+		}
+		s.generators.iterations = n
+		return nil
+	}
+}
+
+// WithMapSize sets the default width and height (in pixels) for new maps.
+func WithMapSize(width, height int) Option {
+	return func(s *Server) error {
+		if width < 1 || height < 1 {
+			return fmt.Errorf("map size must be positive")
+		}
+		s.generators.width, s.generators.height = width, height
+		return nil
+	}
+}
+
+// WithMapCacheSize limits the memory (in bytes) used to cache decoded maps.
+func WithMapCacheSize(n int64) Option {
+	return func(s *Server) error {
+		if n < 0 {
+			return fmt.Errorf("map cache size must not be negative")
+		}
+		s.cache.maps = n
+		return nil
+	}
+}
+
+// WithMaxGenerationTime stops generators that run longer than d.
+// Zero means no limit.
+func WithMaxGenerationTime(d time.Duration) Option {
+	return func(s *Server) error {
+		if d < 0 {
+			return fmt.Errorf("max generation time must not be negative")
+		}
+		s.generators.maxTime = d
+		return nil
+	}
+}
+
+// WithMaxIterations limits the number of iterations a request may ask for.
+func WithMaxIterations(n int) Option {
+	return func(s *Server) error {
+		if n < 0 {
+			return fmt.Errorf("max iterations must not be negative")
+		}
+		s.generators.maxIterations = n
+		return nil
+	}
+}
+
+// WithMaxMapSize limits the width and height (in pixels) a request may ask for.
+func WithMaxMapSize(width, height int) Option {
+	return func(s *Server) error {
+		if width < 1 || height < 1 {
+			return fmt.Errorf("max map size must be positive")
+		}
+		s.generators.maxWidth, s.generators.maxHeight = width, height
+		return nil
+	}
+}
+
 func WithPublic(path string) Option {
 	return func(s *Server) error {
 		if s.root == "" {
@@ -82,6 +175,15 @@ func WithSigningKey(key string) Option {
 	}
 }
 
+// WithStore stores maps in the given store.
+// It is intended for tests, which can use a mapstore.MemoryStore.
+func WithStore(store mapstore.MapStore) Option {
+	return func(s *Server) error {
+		s.store = store
+		return nil
+	}
+}
+
 func WithTemplates(path string) Option {
 	return func(s *Server) error {
 		if s.root == "" {
@@ -91,3 +193,22 @@ func WithTemplates(path string) Option {
 		return nil
 	}
 }
+
+// WithVersion sets the version of mapgen recorded in the metadata for new maps.
+func WithVersion(version string) Option {
+	return func(s *Server) error {
+		s.version = version
+		return nil
+	}
+}
+
+// WithWorkers sets the number of maps that can be generated at the same time.
+func WithWorkers(n int) Option {
+	return func(s *Server) error {
+		if n < 1 {
+			return fmt.Errorf("workers must be positive")
+		}
+		s.generators.workers = n
+		return nil
+	}
+}
@@ -32,13 +32,22 @@ func (s *Server) Routes() {
 		s.router.Handle("GET", "/css...", staticHandler(s.css, "/css"))
 		s.router.Handle("GET", "/favicon.ico", staticFileHandler(s.public, "favicon.ico"))
 		s.router.Handle("POST", "/generate", s.addUser(s.authOnly(s.generateHandler())))
-		s.router.Handle("GET", "/image/:seed/pct-water/:pctWater/pct-ice/:pctIce/shift-x/:shiftX/shift-y/:shiftY/rotate/:rotate/hsl/:hsl", s.imageHandler())
+		s.router.Handle("GET", "/hydrology/:id", s.hydrologyHandler())
+		s.router.Handle("GET", "/image/:id", s.imageHandler())
+		s.router.Handle("GET", "/image/:id/pct-water/:pctWater/pct-ice/:pctIce/shift-x/:shiftX/shift-y/:shiftY/rotate/:rotate/hsl/:hsl", s.legacyHandler("image"))
+		s.router.Handle("GET", "/jobs/:id", s.addUser(s.authOnly(s.jobHandler())))
+		s.router.Handle("POST", "/jobs/:id/cancel", s.addUser(s.authOnly(s.jobCancelHandler())))
+		s.router.Handle("GET", "/jobs/:id/events", s.addUser(s.authOnly(s.jobEventsHandler())))
 		s.router.Handle("POST", "/login", s.loginPostHandler())
 		s.router.Handle("GET", "/logout", s.logoutHandler())
 		s.router.Handle("POST", "/logout", s.logoutHandler())
 		s.router.Handle("GET", "/manage", s.addUser(s.authOnly(s.manageHandler())))
+		s.router.Handle("POST", "/process", s.addUser(s.authOnly(s.processHandler())))
+		s.router.Handle("GET", "/stats", s.addUser(s.authOnly(s.statsHandler())))
+		s.router.Handle("GET", "/thumb/:id", s.thumbHandler())
 		s.router.Handle("POST", "/view", s.viewPostHandler())
-		s.router.Handle("GET", "/view/:id/pct-water/:pctWater/pct-ice/:pctIce/shift-x/:shiftX/shift-y/:shiftY/rotate/:rotate/hsl/:hsl", s.addUser(s.viewHandler()))
+		s.router.Handle("GET", "/view/:id", s.addUser(s.viewHandler()))
+		s.router.Handle("GET", "/view/:id/pct-water/:pctWater/pct-ice/:pctIce/shift-x/:shiftX/shift-y/:shiftY/rotate/:rotate/hsl/:hsl", s.legacyHandler("view"))
 
 		s.router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
@@ -19,22 +19,52 @@ package server
 import (
 	"bytes"
 	"github.com/mdhender/mapgen/pkg/authz"
+	"github.com/mdhender/mapgen/pkg/generators"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"github.com/mdhender/mapgen/pkg/jobs"
+	"github.com/mdhender/mapgen/pkg/lru"
+	"github.com/mdhender/mapgen/pkg/mapstore"
 	"github.com/mdhender/mapgen/pkg/way"
 	"html/template"
 	"log"
 	"net/http"
+	"runtime"
+	"strconv"
 	"sync"
+	"time"
 )
 
+// maxQueuedJobs is the number of generate requests that can wait for a worker.
+const maxQueuedJobs = 64
+
 func New(options ...Option) (*Server, error) {
 	s := &Server{}
 	s.generators.height, s.generators.width = 640, 1280
 	s.generators.iterations = 10_000
+	s.generators.maxHeight, s.generators.maxWidth = 4*1024, 4*1024
+	s.generators.maxIterations = 100_000
+	s.generators.workers = runtime.NumCPU()
+	s.generators.maxTime = 5 * time.Minute
+	s.cache.images = 64 * 1024 * 1024
+	s.cache.maps = 256 * 1024 * 1024
+	s.cache.biomes = 1024 * 1024
 	for _, option := range options {
 		if err := option(s); err != nil {
 			return nil, err
 		}
 	}
+	if s.store == nil {
+		// default to the working directory, like older versions
+		store, err := mapstore.NewFileStore(".", heightmap.EncodeOptions{DataType: heightmap.Float32})
+		if err != nil {
+			return nil, err
+		}
+		s.store = store
+	}
+	s.jobs = jobs.New(s.generators.workers, maxQueuedJobs)
+	s.images = lru.New[imageKey, *renderedImage](s.cache.images, (*renderedImage).size)
+	s.maps = lru.New[string, *cachedMap](s.cache.maps, (*cachedMap).size)
+	s.biomes = lru.New[imageKey, *biomeAreas](s.cache.biomes, (*biomeAreas).size)
 	return s, nil
 }
 
@@ -42,19 +72,40 @@ type Server struct {
 	once sync.Once
 
 	router         *way.Router
+	store          mapstore.MapStore
+	jobs           *jobs.Queue
+	images         *lru.Cache[imageKey, *renderedImage]
+	maps           *lru.Cache[string, *cachedMap]
+	biomes         *lru.Cache[imageKey, *biomeAreas]
+	locks          idLocks
 	secret         string
 	root           string
 	css            string
 	public         string
 	templates      string
+	version        string
 	debugTemplates bool
 	cookies        struct {
 		name   string
 		secure bool
 	}
 	generators struct {
+		// defaults for new maps
 		height, width int
 		iterations    int
+		// limits for new maps
+		maxHeight, maxWidth int
+		maxIterations       int
+		// number of maps that can be generated at the same time
+		workers int
+		// longest time a generator may run, zero for no limit
+		maxTime time.Duration
+	}
+	cache struct {
+		// maximum size (in bytes) of the caches
+		images int64
+		maps   int64
+		biomes int64
 	}
 	jot struct {
 		factory *authz.Factory
@@ -84,6 +135,36 @@ func (s *Server) currentUser(r *http.Request) User {
 	return user
 }
 
+// parameters returns the generator's parameters with the server's defaults
+// and limits applied to the height, width, and iterations parameters.
+func (s *Server) parameters(g generators.Generator) []generators.Parameter {
+	var params []generators.Parameter
+	for _, parm := range g.Parameters() {
+		var dflt, limit int
+		switch parm.Name {
+		case "height":
+			dflt, limit = s.generators.height, s.generators.maxHeight
+		case "width":
+			dflt, limit = s.generators.width, s.generators.maxWidth
+		case "iterations":
+			dflt, limit = s.generators.iterations, s.generators.maxIterations
+		}
+		if limit != 0 {
+			if parm.Max == 0 || float64(limit) < parm.Max {
+				parm.Max = float64(limit)
+			}
+			if dflt < int(parm.Min) {
+				dflt = int(parm.Min)
+			} else if dflt > int(parm.Max) {
+				dflt = int(parm.Max)
+			}
+			parm.Default = strconv.Itoa(dflt)
+		}
+		params = append(params, parm)
+	}
+	return params
+}
+
 func (s *Server) render(w http.ResponseWriter, r *http.Request, rr Renderer, content any) {
 	var page struct {
 		NavBar struct {
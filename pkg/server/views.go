@@ -0,0 +1,237 @@
+// mapgen - fantasy map generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"fmt"
+	"github.com/mdhender/mapgen/pkg/heightmap"
+	"net/url"
+	"strconv"
+)
+
+// view holds the settings used to draw a map.
+// The settings are passed in the query string of the view and image pages.
+type view struct {
+	heightmap.Style
+	Transform heightmap.Pipeline
+}
+
+// defaultView is used for settings missing from the query string.
+var defaultView = view{Style: heightmap.DefaultStyle}
+
+// hslView returns the default settings, with or without the HSL color map.
+func hslView(useHSL bool) view {
+	v := defaultView
+	v.UseHSL = useHSL
+	return v
+}
+
+// viewFromQuery returns the settings from the query string.
+func viewFromQuery(q url.Values) (view, error) {
+	v := defaultView
+	var err error
+	if s := q.Get("water"); s != "" {
+		if v.PctWater, err = strconv.Atoi(s); err != nil {
+			return v, fmt.Errorf("water: must be an integer")
+		}
+	}
+	if s := q.Get("ice"); s != "" {
+		if v.PctIce, err = strconv.Atoi(s); err != nil {
+			return v, fmt.Errorf("ice: must be an integer")
+		}
+	}
+	if s := q.Get("rivers"); s != "" {
+		if v.Rivers, err = strconv.Atoi(s); err != nil {
+			return v, fmt.Errorf("rivers: must be an integer")
+		}
+	}
+	if s := q.Get("lakes"); s != "" {
+		if v.Lakes, err = strconv.ParseBool(s); err != nil {
+			return v, fmt.Errorf("lakes: must be true or false")
+		}
+	}
+	if s := q.Get("flood"); s != "" {
+		if v.Flood, err = strconv.ParseBool(s); err != nil {
+			return v, fmt.Errorf("flood: must be true or false")
+		}
+	}
+	if s := q.Get("hsl"); s != "" {
+		if v.UseHSL, err = strconv.ParseBool(s); err != nil {
+			return v, fmt.Errorf("hsl: must be true or false")
+		}
+	}
+	if s := q.Get("mode"); s != "" {
+		if v.Mode, err = heightmap.ParseMode(s); err != nil {
+			return v, fmt.Errorf("mode: %w", err)
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		value *float64
+	}{
+		{"az", &v.Light.Azimuth},
+		{"alt", &v.Light.Altitude},
+		{"exag", &v.Light.Exaggeration},
+		{"blend", &v.Blend},
+	} {
+		if s := q.Get(p.name); s != "" {
+			if *p.value, err = strconv.ParseFloat(s, 64); err != nil {
+				return v, fmt.Errorf("%s: must be a number", p.name)
+			}
+		}
+	}
+	if s := q.Get("multi"); s != "" {
+		if v.Light.MultiDirectional, err = strconv.ParseBool(s); err != nil {
+			return v, fmt.Errorf("multi: must be true or false")
+		}
+	}
+	if s := q.Get("fc"); s != "" {
+		if v.FalseColor, err = strconv.ParseBool(s); err != nil {
+			return v, fmt.Errorf("fc: must be true or false")
+		}
+	}
+	if err = v.Style.Validate(); err != nil {
+		return v, err
+	}
+	if v.Transform, err = heightmap.ParsePipeline(q.Get("t")); err != nil {
+		return v, fmt.Errorf("t: %w", err)
+	}
+	return v, nil
+}
+
+// query returns the settings as a query string.
+func (v view) query() string {
+	q := url.Values{}
+	q.Set("water", strconv.Itoa(v.PctWater))
+	q.Set("ice", strconv.Itoa(v.PctIce))
+	q.Set("hsl", strconv.FormatBool(v.UseHSL))
+	// settings for drawing the terrain are only added when they are used,
+	// so that links from older versions find the same cached images
+	if v.Mode != defaultView.Mode {
+		q.Set("mode", string(v.Mode))
+	}
+	if v.Mode != heightmap.ModeColor {
+		light := defaultView.Light
+		if v.Light.Azimuth != light.Azimuth {
+			q.Set("az", strconv.FormatFloat(v.Light.Azimuth, 'f', -1, 64))
+		}
+		if v.Light.Altitude != light.Altitude {
+			q.Set("alt", strconv.FormatFloat(v.Light.Altitude, 'f', -1, 64))
+		}
+		if v.Light.Exaggeration != light.Exaggeration {
+			q.Set("exag", strconv.FormatFloat(v.Light.Exaggeration, 'f', -1, 64))
+		}
+		if v.Light.MultiDirectional {
+			q.Set("multi", "true")
+		}
+	}
+	if v.Mode == heightmap.ModeShaded && v.Blend != defaultView.Blend {
+		q.Set("blend", strconv.FormatFloat(v.Blend, 'f', -1, 64))
+	}
+	if v.FalseColor {
+		q.Set("fc", "true")
+	}
+	if v.Rivers != 0 {
+		q.Set("rivers", strconv.Itoa(v.Rivers))
+	}
+	if v.Lakes {
+		q.Set("lakes", "true")
+		if v.Flood {
+			q.Set("flood", "true")
+		}
+	}
+	if len(v.Transform) != 0 {
+		q.Set("t", v.Transform.String())
+	}
+	return q.Encode()
+}
+
+// climateQuery returns the settings that change the climate as a query string.
+// Maps drawn in other ways share the same climate.
+func (v view) climateQuery() string {
+	q := url.Values{}
+	q.Set("water", strconv.Itoa(v.PctWater))
+	if len(v.Transform) != 0 {
+		q.Set("t", v.Transform.String())
+	}
+	return q.Encode()
+}
+
+// render draws the map with the settings.
+// The transforms may not make a map larger than maxWidth by maxHeight.
+// The map is not changed.
+func (v view) render(hm *heightmap.Map, maxWidth, maxHeight int) ([]byte, error) {
+	m, err := v.Transform.ApplyWithin(hm, maxWidth, maxHeight)
+	if err != nil {
+		return nil, err
+	}
+	// the transformed map is a copy, so it can be colored
+	return v.Style.Render(m)
+}
+
+// thumbWidth and thumbHeight are the largest size of a thumbnail.
+const thumbWidth, thumbHeight = 256, 128
+
+// thumbnail draws a small copy of the map with the default settings.
+// The map is not changed.
+func thumbnail(hm *heightmap.Map) ([]byte, error) {
+	maxx, maxy := len(hm.Data), len(hm.Data[0])
+	width, height := thumbWidth, maxy*thumbWidth/maxx
+	if height > thumbHeight {
+		width, height = maxx*thumbHeight/maxy, thumbHeight
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	m, err := hm.Resize(width, height, heightmap.Bilinear)
+	if err != nil {
+		return nil, err
+	}
+	return defaultView.render(m, width, height)
+}
+
+// legacyView converts the settings from the paths used by older versions.
+// Those versions shifted the map after rotating it.
+func legacyView(pctWater, pctIce, shiftX, shiftY int, rotate, useHSL bool) view {
+	v := defaultView
+	v.PctWater, v.PctIce, v.UseHSL = pctWater, pctIce, useHSL
+	if rotate {
+		v.Transform = append(v.Transform, heightmap.Transform{Op: "rotate", Args: []float64{90}})
+	}
+	if shiftX != 0 || shiftY != 0 {
+		v.Transform = append(v.Transform, heightmap.Transform{Op: "shift", Args: []float64{float64(shiftX), float64(shiftY)}})
+	}
+	return v
+}
+
+// viewURL returns the path to the view page for a map.
+func viewURL(id string, v view) string {
+	return "/view/" + id + "?" + v.query()
+}
+
+// hydrologyURL returns the path to the rivers and lakes of a map, as GeoJSON.
+func hydrologyURL(id string, v view) string {
+	return "/hydrology/" + id + "?" + v.query()
+}
+
+// imageURL returns the path to the image for a map.
+func imageURL(id string, v view) string {
+	return "/image/" + id + "?" + v.query()
+}